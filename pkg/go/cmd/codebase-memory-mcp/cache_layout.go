@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// runtimeCacheKey names the platform build a runtime set belongs to:
+// linux-amd64-portable, linux-arm64-native, darwin-arm64, windows-amd64.
+// Linux carries its libc variant because the portable and glibc builds of one
+// version are different binaries.
+func runtimeCacheKey(platform, arch, variant string) string {
+	key := platform + "-" + arch
+	if platform == "linux" && variant != "" {
+		key += "-" + variant
+	}
+	return key
+}
+
+// runtimeCacheRoot holds this machine's version directories. Keying the cache
+// on the platform lets one home directory, shared over NFS or a synced
+// profile, serve machines of different architectures without one host
+// replacing or rejecting another host's binary.
+func runtimeCacheRoot() string {
+	return filepath.Join(
+		cacheDir(), runtimeCacheKey(goos(), goarch(), linuxArchiveVariant()),
+	)
+}
+
+// migrateLegacyRuntimeSet moves a version installed under the older flat
+// layout, cacheDir()/<ver>, into destination instead of downloading it again.
+// The caller holds destination's lock; the legacy directory's lock is taken
+// inside it, always in that order, so neither a wrapper of this layout nor an
+// older wrapper still publishing to the legacy directory can interleave. A
+// legacy set that fails verification, typically another architecture's
+// binary on a shared home, is left for the machine it belongs to. A legacy
+// set that a live process launched is copied but not removed.
+func migrateLegacyRuntimeSet(
+	legacy, destination, binaryName string,
+	verifier func(string) error, lock *runtimeSetLock,
+) (migrated bool, result error) {
+	if requireSafeRuntimeDirectory(legacy) != nil {
+		return false, nil
+	}
+	legacyLock, err := acquireRuntimeSetLock(legacy)
+	if err != nil {
+		return false, err
+	}
+	retired := false
+	defer func() {
+		attachRuntimeLockReleaseError(&result, releaseRuntimeSetLock(legacyLock))
+		if retired && result == nil {
+			removeRetiredRuntimeDirectory(legacy)
+		}
+	}()
+	if err := refreshRuntimeSetLock(legacyLock); err != nil {
+		return false, err
+	}
+	if err := reconcileRuntimeBackups(legacy, binaryName, verifier, legacyLock); err != nil {
+		return false, err
+	}
+	if !runtimeSetReady(legacy, binaryName, verifier) {
+		return false, nil
+	}
+	if err := publishRuntimeSetLocked(
+		legacy, destination, binaryName, verifier, os.Rename, lock,
+	); err != nil {
+		return false, err
+	}
+	if err := copyProvenanceRecord(legacy, destination); err != nil {
+		return false, err
+	}
+	if _, inUse := runtimeVersionInUse(legacy); inUse {
+		return true, nil
+	}
+	// The set is already safe in destination, so failing to empty the
+	// legacy directory only leaves it for "wrapper cache prune".
+	retired = emptyRuntimeVersionDirectory(legacy, legacyLock) == nil
+	return true, nil
+}
+
+// copyProvenanceRecord carries a verified provenance result along with the
+// runtime set it describes.
+func copyProvenanceRecord(sourceDirectory, destinationDirectory string) error {
+	body, err := readLocalDocument(
+		filepath.Join(sourceDirectory, provenanceRecordName), maxProvenanceBundleSize,
+	)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var record json.RawMessage
+	if err := json.Unmarshal(body, &record); err != nil {
+		return fmt.Errorf("%s: %w", provenanceRecordName, err)
+	}
+	return writeJSONFileAtomic(
+		filepath.Join(destinationDirectory, provenanceRecordName), record,
+	)
+}
+
+// wrapperCachePruneLegacy removes version directories of the flat layout that
+// predates per-platform keys. Automatic collection never touches them, since
+// on a shared home they may be another machine's only install. The version of
+// a running daemon is kept, as it may have been started from here.
+func wrapperCachePruneLegacy(cache string, dryRun bool, stdout io.Writer) error {
+	versions, err := cachedVersions(cache)
+	if err != nil {
+		return err
+	}
+	action := "removed"
+	if dryRun {
+		action = "would remove"
+	}
+	daemon, daemonRunning := findRunningDaemon(nativeCacheDir())
+	for _, ver := range versions {
+		directory := filepath.Join(cache, ver)
+		if daemonRunning && daemon.Version == ver {
+			fmt.Fprintf(stdout, "kept legacy v%s: daemon running as process %d\n", ver, daemon.PID)
+			continue
+		}
+		kept := false
+		err := withRuntimeSetLock(directory, func(lock *runtimeSetLock) error {
+			if pid, inUse := runtimeVersionInUse(directory); inUse {
+				fmt.Fprintf(stdout, "kept legacy v%s: in use by process %d\n", ver, pid)
+				kept = true
+				return nil
+			}
+			if dryRun {
+				return nil
+			}
+			return emptyRuntimeVersionDirectory(directory, lock)
+		})
+		if err != nil {
+			return fmt.Errorf("legacy v%s: %w", ver, err)
+		}
+		if kept {
+			continue
+		}
+		if !dryRun {
+			removeRetiredRuntimeDirectory(directory)
+		}
+		fmt.Fprintf(stdout, "%s legacy v%s\n", action, ver)
+	}
+	return nil
+}
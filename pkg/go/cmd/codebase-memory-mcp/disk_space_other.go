@@ -0,0 +1,11 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+func platformFreeDiskSpace(path string) (uint64, bool) {
+	return 0, false
+}
+
+func platformDirectoryWritable(directory string) (bool, bool) {
+	return false, false
+}
@@ -0,0 +1,24 @@
+//go:build darwin || linux
+
+package main
+
+import "syscall"
+
+// accessWrite is access(2)'s W_OK, which package syscall does not export.
+const accessWrite = 0x2
+
+// platformFreeDiskSpace returns the bytes available to this user on the
+// file system holding path.
+func platformFreeDiskSpace(path string) (uint64, bool) {
+	var status syscall.Statfs_t
+	if err := syscall.Statfs(path, &status); err != nil {
+		return 0, false
+	}
+	return uint64(status.Bavail) * uint64(status.Bsize), true
+}
+
+// platformDirectoryWritable asks the kernel whether this user may create
+// entries in directory, without creating one.
+func platformDirectoryWritable(directory string) (bool, bool) {
+	return syscall.Access(directory, accessWrite) == nil, true
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var windowsGetDiskFreeSpaceEx = windowsKernel32.NewProc("GetDiskFreeSpaceExW")
+
+// platformFreeDiskSpace returns the bytes available to this user on the
+// volume holding path, honouring quotas.
+func platformFreeDiskSpace(path string) (uint64, bool) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	var available uint64
+	result, _, _ := windowsGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&available)), 0, 0,
+	)
+	return available, result != 0
+}
+
+// platformDirectoryWritable cannot answer on Windows without evaluating the
+// directory's ACL against the process token, which doctor does not attempt.
+func platformDirectoryWritable(directory string) (bool, bool) {
+	return false, false
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	doctorNetworkTimeout = 15 * time.Second
+	doctorConflictCount  = 5
+	// doctorMinFreeSpace covers an archive, its extraction and the staged
+	// copy of one release with room to spare.
+	doctorMinFreeSpace = 512 * 1024 * 1024
+)
+
+// doctorCheck is one finding of "wrapper doctor". Status is "ok", "warn",
+// "fail" or "skip"; Details carries the raw values for --json.
+type doctorCheck struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status"`
+	Summary string         `json:"summary"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// daemonConflict is one daemon.version_conflict event from the native
+// daemon-conflicts.ndjson log.
+type daemonConflict struct {
+	Timestamp        int64  `json:"timestamp_unix_s"`
+	Reason           string `json:"reason"`
+	ActiveVersion    string `json:"active_version"`
+	RequestedVersion string `json:"requested_version"`
+}
+
+// wrapperDoctor diagnoses the selected version's install end to end: where
+// the cache is and why, whether it is safe, who holds its lock, pending
+// publication journals, the binary itself, disk space, the release host and
+// recent daemon version conflicts. It never takes a lock and never creates,
+// changes or removes anything in the cache. It returns false when any check
+// failed.
+func wrapperDoctor(asJSON bool, stdout io.Writer) bool {
+	var checks []doctorCheck
+	add := func(name, status, summary string, details map[string]any) {
+		checks = append(checks, doctorCheck{name, status, summary, details})
+	}
+
+	cache, cacheSource := cacheDirWithSource()
+	add("cache", "ok", fmt.Sprintf("%s (from %s)", cache, cacheSource),
+		map[string]any{"path": cache, "source": cacheSource})
+
+	ver, err := selectedVersion()
+	if err != nil {
+		add("version", "fail", err.Error(), nil)
+		return printDoctorReport(checks, asJSON, stdout)
+	}
+	binary := binPath(ver)
+	directory, binaryName := filepath.Dir(binary), filepath.Base(binary)
+	add("version", "ok", fmt.Sprintf("v%s in %s", ver, directory),
+		map[string]any{"version": ver, "directory": directory})
+
+	checks = append(checks, doctorSafety(cache, directory))
+	checks = append(checks, doctorLock(directory))
+	checks = append(checks, doctorJournals(directory, binaryName))
+	checks = append(checks, doctorBinary(directory, binaryName, ver))
+	checks = append(checks, doctorDiskSpace(cache))
+	checks = append(checks, doctorNetwork(ver))
+	checks = append(checks, doctorDaemon(ver))
+	return printDoctorReport(checks, asJSON, stdout)
+}
+
+func printDoctorReport(checks []doctorCheck, asJSON bool, stdout io.Writer) bool {
+	healthy := !slices.ContainsFunc(checks, func(check doctorCheck) bool {
+		return check.Status == "fail"
+	})
+	if asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(struct {
+			Healthy bool          `json:"healthy"`
+			Checks  []doctorCheck `json:"checks"`
+		}{healthy, checks})
+		return healthy
+	}
+	for _, check := range checks {
+		fmt.Fprintf(stdout, "%-6s %-10s %s\n", "["+check.Status+"]", check.Name, check.Summary)
+	}
+	return healthy
+}
+
+// doctorSafety applies requireSafeRuntimeDirectory to each level of the
+// cache the wrapper will use, and asks the platform whether the version
+// directory is writable rather than writing a probe into it.
+func doctorSafety(cache, directory string) doctorCheck {
+	check := doctorCheck{Name: "safety", Status: "ok", Summary: "cache directories are real directories"}
+	for _, level := range []string{cache, filepath.Dir(directory), directory} {
+		if _, err := os.Lstat(level); os.IsNotExist(err) {
+			check.Summary = fmt.Sprintf("%s does not exist yet; the first launch creates it", level)
+			return check
+		}
+		if err := requireSafeRuntimeDirectory(level); err != nil {
+			return doctorCheck{Name: "safety", Status: "fail", Summary: err.Error()}
+		}
+	}
+	writable, known := platformDirectoryWritable(directory)
+	switch {
+	case !known:
+		check.Summary += "; writability is not checked on " + runtime.GOOS
+	case !writable:
+		return doctorCheck{Name: "safety", Status: "fail",
+			Summary: fmt.Sprintf("%s is not writable by this user", directory)}
+	}
+	return check
+}
+
+// doctorLock shows who holds the version's runtime-set lock. The next launch
+// reclaims it from a local owner that is no longer running, and from one on
+// another host, boot or PID namespace once its lease runs out.
+func doctorLock(directory string) doctorCheck {
+	lockPath := filepath.Join(directory, runtimeSetLockName)
+	owner, held := runtimeSetLockOwner(lockPath)
+	status, err := os.Lstat(lockPath)
+	if !held || err != nil {
+		return doctorCheck{Name: "lock", Status: "ok", Summary: "not held"}
+	}
+	alive := !runtimeSetOwnerReclaimable(status, owner, true)
+	details := map[string]any{
+		"pid": owner.PID, "alive": alive, "phase": owner.Phase,
+		"done_bytes": owner.Done, "total_bytes": owner.Total,
+	}
+	if !runtimeSetOwnerLocal(owner) {
+		details["host"] = owner.Host
+		activity := describeProgress(owner.Phase, owner.Done, owner.Total)
+		if alive {
+			return doctorCheck{Name: "lock", Status: "ok", Details: details, Summary: fmt.Sprintf(
+				"held by process %d on %s, another host, boot or PID namespace, with a current lease: %s",
+				owner.PID, owner.Host, activity)}
+		}
+		return doctorCheck{Name: "lock", Status: "warn", Details: details, Summary: fmt.Sprintf(
+			"held by process %d on %s, whose lease expired; the next launch or \"wrapper lock break\" reclaims it",
+			owner.PID, owner.Host)}
+	}
+	var lease string
+	if owner.LeaseExpires > 0 {
+		expires := time.UnixMilli(owner.LeaseExpires)
+		details["lease_expires"] = expires.UTC().Format(time.RFC3339)
+		lease = fmt.Sprintf(", lease ends in %s", time.Until(expires).Round(time.Second))
+		if time.Now().After(expires) {
+			lease = ", lease expired"
+		}
+	}
+	activity := describeProgress(owner.Phase, owner.Done, owner.Total)
+	if alive {
+		return doctorCheck{Name: "lock", Status: "ok", Details: details, Summary: fmt.Sprintf(
+			"held by live process %d: %s%s", owner.PID, activity, lease)}
+	}
+	return doctorCheck{Name: "lock", Status: "warn", Details: details, Summary: fmt.Sprintf(
+		"held by process %d, which is not running%s; the next launch or \"wrapper lock break\" reclaims it", owner.PID, lease)}
+}
+
+// doctorJournals lists publication journals a crash left behind. The next
+// launch finishes or rolls back each one it can; "wrapper repair" the rest.
+func doctorJournals(directory, binaryName string) doctorCheck {
+	backups, err := listRuntimeBackupDirectories(directory, binaryName)
+	if os.IsNotExist(err) {
+		return doctorCheck{Name: "journals", Status: "ok", Summary: "none"}
+	}
+	if err != nil {
+		return doctorCheck{Name: "journals", Status: "fail", Summary: err.Error()}
+	}
+	if len(backups) == 0 {
+		return doctorCheck{Name: "journals", Status: "ok", Summary: "none"}
+	}
+	var pending []map[string]any
+	for _, backup := range backups {
+		pending = append(pending, map[string]any{
+			"path": backup.path, "retired": backup.retired, "cleanup_only": backup.cleanupOnly,
+		})
+	}
+	return doctorCheck{
+		Name: "journals", Status: "warn", Details: map[string]any{"pending": pending},
+		Summary: fmt.Sprintf("%d pending; the next launch reconciles them, or \"wrapper repair\" when it cannot", len(backups)),
+	}
+}
+
+// doctorBinary verifies the installed runtime set the way "wrapper cache
+// verify" does, but without taking its lock: a set whose lock is held before
+// or after verification may be mid-publication, so it is skipped.
+func doctorBinary(directory, binaryName, ver string) doctorCheck {
+	if !regularRuntimeFile(filepath.Join(directory, binaryName)) {
+		if record := readRuntimeReuseRecord(directory); record.Reused != nil {
+			return doctorCheck{Name: "binary", Status: "ok",
+				Summary: "reuses " + record.Reused.Path, Details: map[string]any{"reuses": record.Reused.Path}}
+		}
+		return doctorCheck{Name: "binary", Status: "warn", Summary: "not installed; the next launch installs it"}
+	}
+	if runtimeSetVerifiedUnlocked(directory, binaryName) {
+		record, _ := readRuntimeVerifiedRecord(directory)
+		if verifyCachedRuntimeSet(directory, binaryName, cachedVersionVerifier(ver)) == nil {
+			return doctorCheck{Name: "binary", Status: "ok", Summary: "verified, sha256 " + record.SHA256,
+				Details: map[string]any{"sha256": record.SHA256}}
+		}
+	}
+	lockPath := filepath.Join(directory, runtimeSetLockName)
+	locked := func() bool {
+		_, err := os.Lstat(lockPath)
+		return err == nil
+	}
+	skip := doctorCheck{Name: "binary", Status: "skip", Summary: "not verified: another process holds the lock"}
+	if locked() {
+		return skip
+	}
+	problem := verifyCachedRuntimeSet(directory, binaryName, cachedVersionVerifier(ver))
+	if locked() {
+		return skip
+	}
+	if problem != nil {
+		return doctorCheck{Name: "binary", Status: "fail", Summary: problem.Error()}
+	}
+	return doctorCheck{Name: "binary", Status: "ok", Summary: "verified; the next launch records it"}
+}
+
+func doctorDiskSpace(cache string) doctorCheck {
+	probe := cache
+	for {
+		if _, err := os.Stat(probe); err == nil || filepath.Dir(probe) == probe {
+			break
+		}
+		probe = filepath.Dir(probe)
+	}
+	free, ok := platformFreeDiskSpace(probe)
+	if !ok {
+		return doctorCheck{Name: "disk", Status: "skip", Summary: "free space is not available on " + runtime.GOOS}
+	}
+	summary := fmt.Sprintf("%.1f GiB free on %s", float64(free)/(1<<30), probe)
+	status := "ok"
+	if free < doctorMinFreeSpace {
+		status = "warn"
+		summary += "; an install needs about 512 MiB"
+	}
+	return doctorCheck{Name: "disk", Status: status, Summary: summary,
+		Details: map[string]any{"free_bytes": free, "path": probe}}
+}
+
+// doctorNetwork fetches the release's checksums.txt through the configured
+// proxy and TLS settings, which is the first request an install makes.
+func doctorNetwork(ver string) doctorCheck {
+	if offline, source, err := offlinePolicy(); err != nil || offline {
+		if err != nil {
+			return doctorCheck{Name: "network", Status: "fail", Summary: err.Error()}
+		}
+		return doctorCheck{Name: "network", Status: "skip", Summary: "not checked: offline policy set by " + source}
+	}
+	source, err := releaseSourceFromSettings()
+	if err == nil {
+		err = configureReleaseNetwork()
+	}
+	if err != nil {
+		return doctorCheck{Name: "network", Status: "fail", Summary: err.Error()}
+	}
+	target := source.assetURL(ver, "checksums.txt")
+	request, err := newReleaseRequest(target, source.credential)
+	if err != nil {
+		return doctorCheck{Name: "network", Status: "fail", Summary: err.Error()}
+	}
+	details := map[string]any{"url": target, "proxy": "direct"}
+	if transport, _, err := releaseTransportFromSettings(); err == nil && transport.Proxy != nil {
+		if proxy, err := transport.Proxy(request); err == nil && proxy != nil {
+			details["proxy"] = proxy.Redacted()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorNetworkTimeout)
+	defer cancel()
+	started := time.Now()
+	response, err := httpsOnlyClient.Do(request.WithContext(ctx)) //nolint:gosec
+	if err != nil {
+		return doctorCheck{Name: "network", Status: "fail", Details: details,
+			Summary: fmt.Sprintf("%s via %s: %v", request.URL.Host, details["proxy"], err)}
+	}
+	_ = response.Body.Close()
+	details["status"] = response.StatusCode
+	details["elapsed_ms"] = time.Since(started).Milliseconds()
+	protocol := "TLS"
+	if response.TLS != nil {
+		protocol = tls.VersionName(response.TLS.Version)
+		details["tls"] = protocol
+	}
+	summary := fmt.Sprintf("%s via %s over %s: HTTP %d", request.URL.Host, details["proxy"], protocol, response.StatusCode)
+	if response.StatusCode != 200 {
+		return doctorCheck{Name: "network", Status: "fail", Details: details, Summary: summary}
+	}
+	return doctorCheck{Name: "network", Status: "ok", Details: details, Summary: summary}
+}
+
+// doctorDaemon shows the running daemon and the newest version conflicts it
+// logged, which explain clients refused by a daemon of another version.
+func doctorDaemon(ver string) doctorCheck {
+	check := doctorCheck{Name: "daemon", Status: "ok", Summary: "no daemon running", Details: map[string]any{}}
+	native := nativeCacheDir()
+	if daemon, running := findRunningDaemon(native); running {
+		check.Details["running_version"], check.Details["pid"] = daemon.Version, daemon.PID
+		check.Summary = fmt.Sprintf("v%s running as process %d", daemon.Version, daemon.PID)
+		if daemon.Version != ver {
+			check.Status = "warn"
+			check.Summary += fmt.Sprintf("; launches of v%s will be refused by it", ver)
+		}
+	}
+	conflicts := newestDaemonConflicts(native, doctorConflictCount)
+	if len(conflicts) > 0 {
+		check.Details["conflicts"] = conflicts
+		latest := conflicts[0]
+		check.Summary += fmt.Sprintf(
+			"; last conflict %s: v%s refused v%s (%s)",
+			time.Unix(latest.Timestamp, 0).Format(time.RFC3339),
+			latest.ActiveVersion, latest.RequestedVersion, latest.Reason,
+		)
+	}
+	return check
+}
+
+// newestDaemonConflicts reads logs/daemon-conflicts.ndjson newest first.
+func newestDaemonConflicts(cache string, limit int) []daemonConflict {
+	if cache == "" {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(cache, "logs", "daemon-conflicts.ndjson"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var conflicts []daemonConflict
+	scanner := bufio.NewScanner(io.LimitReader(file, maxDaemonLogScan))
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for scanner.Scan() {
+		var event struct {
+			Event string `json:"event"`
+			daemonConflict
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if json.Unmarshal([]byte(line), &event) != nil || event.Event != "daemon.version_conflict" {
+			continue
+		}
+		conflicts = append(conflicts, event.daemonConflict)
+	}
+	slices.Reverse(conflicts)
+	return conflicts[:min(limit, len(conflicts))]
+}
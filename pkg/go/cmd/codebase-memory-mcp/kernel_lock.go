@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// runtimeSetKernelLockName is locked with flock, or LockFileEx on
+	// Windows, before the link-based runtime-set lock is claimed. Only an
+	// owner retiring its directory removes it, and only while it still holds
+	// it: unlinking a file another process locked would let a third lock a
+	// new one beside it.
+	runtimeSetKernelLockName = ".codebase-memory-mcp-runtime.flock"
+	// runtimeSetKernelLockTick is how often a process blocked on the kernel
+	// lock reads the owner's progress to decide whether to keep waiting.
+	runtimeSetKernelLockTick = 100 * time.Millisecond
+)
+
+var (
+	errRuntimeSetKernelLockBusy        = errors.New("kernel lock is held")
+	errRuntimeSetKernelLockUnsupported = errors.New("kernel locking is not supported here")
+)
+
+// runtimeSetKernelLock is an advisory lock the kernel releases when its
+// process dies. It does not replace the link-based lock, which wrappers that
+// predate it still honour, but it queues contenders without polling, and a
+// contender holding it knows that any link-lock owner which recorded the same
+// kernel lock on the same kernel is gone.
+type runtimeSetKernelLock struct {
+	file     *os.File
+	identity string
+}
+
+// acquireRuntimeSetKernelLock locks directory's kernel lock file, blocking
+// while keepWaiting allows. It returns a nil lock and no error where the
+// filesystem or platform offers no kernel locking, leaving the link-based
+// protocol to exclude on its own.
+func acquireRuntimeSetKernelLock(
+	directory string, keepWaiting func() bool,
+) (*runtimeSetKernelLock, error) {
+	path := filepath.Join(directory, runtimeSetKernelLockName)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		err = platformLockRuntimeSetKernelFile(file, false)
+		if errors.Is(err, errRuntimeSetKernelLockBusy) {
+			err = waitRuntimeSetKernelLock(file, keepWaiting)
+			if err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			_ = file.Close()
+			if errors.Is(err, errRuntimeSetKernelLockUnsupported) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		identity, current, err := runtimeSetKernelLockFileCurrent(path, file)
+		if current && identity != "" {
+			return &runtimeSetKernelLock{file: file, identity: identity}, nil
+		}
+		_ = platformUnlockRuntimeSetKernelFile(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if current {
+			// A lock file that cannot be named cannot be recorded for the
+			// reclaim shortcut, so it adds nothing to the link protocol.
+			return nil, nil
+		}
+		// The file was removed with an emptied version directory while this
+		// process waited for it; lock the one now at path instead.
+	}
+}
+
+// waitRuntimeSetKernelLock blocks on file's lock in the background and
+// consults keepWaiting every runtimeSetKernelLockTick. A wait given up on
+// leaves the blocked call to unlock and close file once it returns. On any
+// result other than success, file is no longer the caller's.
+func waitRuntimeSetKernelLock(file *os.File, keepWaiting func() bool) error {
+	if !keepWaiting() {
+		_ = file.Close()
+		return errRuntimeSetLockTimeout
+	}
+	var mutex sync.Mutex
+	abandoned := false
+	locked := make(chan error, 1)
+	go func() {
+		err := platformLockRuntimeSetKernelFile(file, true)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if abandoned {
+			if err == nil {
+				_ = platformUnlockRuntimeSetKernelFile(file)
+			}
+			_ = file.Close()
+			return
+		}
+		locked <- err
+	}()
+	ticker := time.NewTicker(runtimeSetKernelLockTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-locked:
+			if err != nil {
+				_ = file.Close()
+			}
+			return err
+		case <-ticker.C:
+			if keepWaiting() {
+				continue
+			}
+			mutex.Lock()
+			select {
+			case err := <-locked:
+				mutex.Unlock()
+				if err != nil {
+					_ = file.Close()
+				}
+				return err
+			default:
+			}
+			abandoned = true
+			mutex.Unlock()
+			return errRuntimeSetLockTimeout
+		}
+	}
+}
+
+// runtimeSetKernelLockFileCurrent reports whether the locked file is still
+// the regular file at path, and names it when the platform can.
+func runtimeSetKernelLockFileCurrent(path string, file *os.File) (string, bool, error) {
+	descriptorStatus, err := file.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	canonicalStatus, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if !canonicalStatus.Mode().IsRegular() ||
+		!platformRuntimeSetFileLinkCountOne(path, canonicalStatus) {
+		return "", false, fmt.Errorf("refusing unsafe package-cache kernel lock: %s", path)
+	}
+	if !os.SameFile(descriptorStatus, canonicalStatus) {
+		return "", false, nil
+	}
+	return platformRuntimeFileIdentity(path, canonicalStatus), true, nil
+}
+
+func releaseRuntimeSetKernelLock(kernel *runtimeSetKernelLock) {
+	if kernel == nil || kernel.file == nil {
+		return
+	}
+	_ = platformUnlockRuntimeSetKernelFile(kernel.file)
+	_ = kernel.file.Close()
+	kernel.file = nil
+}
+
+// reclaimable extends runtimeSetOwnerReclaimable for a contender holding the
+// kernel lock: a link-lock owner that recorded this same kernel lock file
+// would still hold it if it were alive, so it is gone and its lock can be
+// taken at once. This holds only on one kernel, which the boot ID proves;
+// without one, only an owner on this host and in this PID namespace counts.
+// Owners that recorded no kernel lock, including older wrappers, are judged
+// as before.
+func (kernel *runtimeSetKernelLock) reclaimable(
+	status os.FileInfo, owner runtimeSetLockOwnerRecord, ownerOK bool,
+) bool {
+	if ownerOK && owner.KernelLock != "" && owner.KernelLock == kernel.identity &&
+		owner.Host != "" {
+		local := runtimeSetLocalHost()
+		if owner.BootID != "" && owner.BootID == local.BootID {
+			return true
+		}
+		if owner.BootID == "" && runtimeSetOwnerLocal(owner) {
+			return true
+		}
+	}
+	return runtimeSetOwnerReclaimable(status, owner, ownerOK)
+}
+
+// unlinkRetiredRuntimeSetKernelLock removes the kernel lock file of a version
+// directory emptied under lock, while the kernel lock is still held. A
+// contender blocked on the file then finds it unlinked once the lock is
+// released and locks a new one; unlinking it after the release could remove
+// a file a contender already holds, and let a third process lock another
+// beside it. Windows cannot unlink an open file, so there the file goes with
+// the directory instead.
+func unlinkRetiredRuntimeSetKernelLock(lock *runtimeSetLock) {
+	path := filepath.Join(filepath.Dir(lock.path), runtimeSetKernelLockName)
+	if lock.kernel == nil {
+		// Nobody holds a kernel lock this filesystem cannot offer.
+		_ = os.Remove(path)
+		return
+	}
+	if _, current, _ := runtimeSetKernelLockFileCurrent(path, lock.kernel.file); current {
+		_ = os.Remove(path)
+	}
+}
+
+// removeRetiredRuntimeDirectory removes a version directory emptied under its
+// lock, after the lock is released, with any protocol files left in it. It
+// fails harmlessly when a launch has started using the directory again.
+func removeRetiredRuntimeDirectory(directory string) {
+	_, _ = removeStaleRuntimeLockFiles(directory, false)
+	if runtime.GOOS == "windows" {
+		// The removal fails while any contender still has the file open.
+		_ = os.Remove(filepath.Join(directory, runtimeSetKernelLockName))
+	}
+	_ = os.Remove(directory)
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "os"
+
+// Unsupported wrapper platforms rely on the link-based protocol alone.
+func platformLockRuntimeSetKernelFile(*os.File, bool) error {
+	return errRuntimeSetKernelLockUnsupported
+}
+
+func platformUnlockRuntimeSetKernelFile(*os.File) error {
+	return nil
+}
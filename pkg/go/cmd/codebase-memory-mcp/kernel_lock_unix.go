@@ -0,0 +1,40 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// platformLockRuntimeSetKernelFile takes an exclusive flock, which belongs to
+// the open file description and so excludes other opens of the file within
+// this process too. Linux NFS clients map it to a byte-range lock; a mount
+// without lock support reports ENOLCK and the link protocol is used alone.
+func platformLockRuntimeSetKernelFile(file *os.File, block bool) error {
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errRuntimeSetKernelLockBusy
+		case errors.Is(err, syscall.ENOLCK), errors.Is(err, syscall.EOPNOTSUPP),
+			errors.Is(err, syscall.ENOSYS), errors.Is(err, syscall.EINVAL):
+			return errRuntimeSetKernelLockUnsupported
+		default:
+			return err
+		}
+	}
+}
+
+func platformUnlockRuntimeSetKernelFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	windowsLockfileFailImmediately = 0x00000001
+	windowsLockfileExclusiveLock   = 0x00000002
+	windowsErrorLockViolation      = syscall.Errno(33)
+	windowsErrorNotSupported       = syscall.Errno(50)
+	windowsErrorInvalidFunction    = syscall.Errno(1)
+)
+
+var (
+	windowsLockFileEx   = windowsKernel32.NewProc("LockFileEx")
+	windowsUnlockFileEx = windowsKernel32.NewProc("UnlockFileEx")
+)
+
+// platformLockRuntimeSetKernelFile locks the file's first byte with
+// LockFileEx. The lock belongs to this handle, so other handles in this
+// process are excluded too, and Windows drops it when the process exits.
+func platformLockRuntimeSetKernelFile(file *os.File, block bool) error {
+	flags := uintptr(windowsLockfileExclusiveLock)
+	if !block {
+		flags |= windowsLockfileFailImmediately
+	}
+	var overlapped syscall.Overlapped
+	result, _, err := windowsLockFileEx.Call(
+		file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if result != 0 {
+		return nil
+	}
+	switch err {
+	case windowsErrorLockViolation, syscall.ERROR_IO_PENDING:
+		return errRuntimeSetKernelLockBusy
+	case windowsErrorNotSupported, windowsErrorInvalidFunction:
+		return errRuntimeSetKernelLockUnsupported
+	default:
+		return err
+	}
+}
+
+func platformUnlockRuntimeSetKernelFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	result, _, err := windowsUnlockFileEx.Call(
+		file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if result == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	linuxVariantPortable = "portable"
+	linuxVariantNative   = "native"
+	// nativeLinuxGlibc is the oldest glibc the native Linux build runs on.
+	nativeLinuxGlibc = "2.38"
+)
+
+// linuxLibc describes the host C library: name is "glibc", "musl" or empty
+// when it could not be told, and version is set for glibc only.
+type linuxLibc struct {
+	name    string
+	version string
+}
+
+// linuxArchiveVariants returns the Linux builds to install, preferred first.
+// CBM_GO_LIBC=portable or native asks for one build and falls back to the
+// other only when a release does not publish it. The default, auto, prefers
+// the smaller native build on a glibc new enough to run it and the static
+// portable build everywhere else, including musl, where native cannot run
+// and is never tried. Other platforms have a single build, named "".
+func linuxArchiveVariants() ([]string, error) {
+	setting := wrapperSetting("CBM_GO_LIBC")
+	switch setting {
+	case "", "auto", linuxVariantPortable, linuxVariantNative:
+	default:
+		return nil, fmt.Errorf(
+			"CBM_GO_LIBC must be \"auto\", \"portable\" or \"native\"",
+		)
+	}
+	if goos() != "linux" {
+		return []string{""}, nil
+	}
+	switch setting {
+	case linuxVariantPortable:
+		return []string{linuxVariantPortable, linuxVariantNative}, nil
+	case linuxVariantNative:
+		return []string{linuxVariantNative, linuxVariantPortable}, nil
+	}
+	if libc := linuxHostLibc(); libc.name == "glibc" &&
+		dottedVersionAtLeast(libc.version, nativeLinuxGlibc) {
+		return []string{linuxVariantNative, linuxVariantPortable}, nil
+	}
+	return []string{linuxVariantPortable}, nil
+}
+
+// linuxArchiveVariant is the preferred build, which also names this host's
+// cache directory. A release that does not publish it installs the fallback
+// build in that same directory, so the directory name says which build was
+// asked for and the set's provenance record says which one it holds. An
+// invalid CBM_GO_LIBC is reported by the install that needs it; until then
+// the portable build's directory is used.
+func linuxArchiveVariant() string {
+	variants, err := linuxArchiveVariants()
+	if err != nil {
+		return linuxVariantPortable
+	}
+	return variants[0]
+}
+
+// runtimeSetBuildUsable re-resolves the build a cached set holds against the
+// builds this host would install now. A directory shared between hosts can
+// hold a fallback build another host chose, such as the glibc build in the
+// portable directory, which a musl host must not run unverified. A set
+// without a provenance record, or with an unreadable one, predates the record
+// and is left to the locked path's own verification.
+func runtimeSetBuildUsable(directory string) bool {
+	contents, err := readLocalDocument(
+		filepath.Join(directory, provenanceRecordName), maxProvenanceBundleSize,
+	)
+	if err != nil {
+		return true
+	}
+	var record provenanceRecord
+	if json.Unmarshal(contents, &record) != nil || record.Archive == "" {
+		return true
+	}
+	variants, err := linuxArchiveVariants()
+	if err != nil {
+		return true
+	}
+	for _, variant := range variants {
+		if record.Archive == releaseArchiveName(goos(), goarch(), variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLinuxLibc identifies the host C library from the dynamic loader: the
+// wrapper's own ELF interpreter when it was linked dynamically, otherwise the
+// loaders installed at their standard paths. glibc is recognised only by a
+// libc.so.6 that defines GLIBC_* symbol versions, so a musl system with a
+// glibc compatibility loader is still reported as musl.
+func detectLinuxLibc() linuxLibc {
+	loaders := []string{}
+	if interpreter := elfInterpreter("/proc/self/exe"); interpreter != "" {
+		loaders = append(loaders, interpreter)
+	}
+	loaders = append(loaders,
+		"/lib64/ld-linux-x86-64.so.2", "/lib/ld-linux-x86-64.so.2",
+		"/lib/ld-linux-aarch64.so.1", "/lib64/ld-linux-aarch64.so.1",
+	)
+	for _, loader := range loaders {
+		if !strings.Contains(filepath.Base(loader), "ld-linux") {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(loader)
+		if err != nil {
+			continue
+		}
+		for _, directory := range []string{
+			filepath.Dir(resolved), filepath.Dir(loader),
+		} {
+			if version := glibcVersion(filepath.Join(directory, "libc.so.6")); version != "" {
+				return linuxLibc{name: "glibc", version: version}
+			}
+		}
+	}
+	musl, _ := filepath.Glob("/lib/ld-musl-*.so.1")
+	if len(musl) > 0 || strings.Contains(elfInterpreter("/proc/self/exe"), "ld-musl") {
+		return linuxLibc{name: "musl"}
+	}
+	return linuxLibc{}
+}
+
+// elfInterpreter returns the PT_INTERP path of an ELF file, or "" for a
+// static binary or anything unreadable.
+func elfInterpreter(path string) string {
+	file, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	for _, program := range file.Progs {
+		if program.Type != elf.PT_INTERP {
+			continue
+		}
+		interpreter, err := io.ReadAll(io.LimitReader(program.Open(), 4096))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimRight(string(interpreter), "\x00")
+	}
+	return ""
+}
+
+// glibcVersion returns the newest GLIBC_x.y version a libc.so.6 defines.
+func glibcVersion(path string) string {
+	file, err := elf.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	versions, err := file.DynamicVersions()
+	if err != nil {
+		return ""
+	}
+	newest := ""
+	for _, defined := range versions {
+		number, ok := strings.CutPrefix(defined.Name, "GLIBC_")
+		if !ok || !dottedVersionAtLeast(number, "0") {
+			continue
+		}
+		if newest == "" || dottedVersionAtLeast(number, newest) {
+			newest = number
+		}
+	}
+	return newest
+}
+
+// dottedVersionAtLeast compares dotted numeric versions such as "2.2.5" and
+// "2.38"; anything that is not one compares as too old.
+func dottedVersionAtLeast(have, want string) bool {
+	parse := func(text string) ([]int, bool) {
+		var parts []int
+		for _, field := range strings.Split(text, ".") {
+			part, err := strconv.Atoi(field)
+			if err != nil || part < 0 {
+				return nil, false
+			}
+			parts = append(parts, part)
+		}
+		return parts, true
+	}
+	haveParts, ok := parse(have)
+	if !ok {
+		return false
+	}
+	wantParts, ok := parse(want)
+	if !ok {
+		return false
+	}
+	for index := range max(len(haveParts), len(wantParts)) {
+		var left, right int
+		if index < len(haveParts) {
+			left = haveParts[index]
+		}
+		if index < len(wantParts) {
+			right = wantParts[index]
+		}
+		if left != right {
+			return left > right
+		}
+	}
+	return true
+}
+
+// chooseArchive picks the first of archives the release manifest lists. When
+// none is listed the preferred one is returned, so the checksum check reports
+// the missing entry by name.
+func chooseArchive(archives []string, checksums map[string]string) string {
+	for _, archive := range archives {
+		if _, listed := checksums[archive]; listed {
+			return archive
+		}
+	}
+	return archives[0]
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runtimeLockReport is what "wrapper lock show" knows about a version's
+// runtime-set lock. Age is measured from the lock file's last write, which
+// its owner's heartbeat rewrites every runtimeSetLockHeartbeat. Alive is the
+// reclaim rule's verdict: for a foreign owner, one on another host, boot or
+// PID namespace, it means its lease has not run out.
+type runtimeLockReport struct {
+	Path         string                `json:"path"`
+	Held         bool                  `json:"held"`
+	Shape        string                `json:"shape,omitempty"`
+	PID          int                   `json:"pid,omitempty"`
+	Token        string                `json:"token,omitempty"`
+	Host         string                `json:"host,omitempty"`
+	StartTime    string                `json:"start_time,omitempty"`
+	Foreign      bool                  `json:"foreign,omitempty"`
+	KernelLock   bool                  `json:"kernel_lock,omitempty"`
+	Alive        bool                  `json:"alive"`
+	LeaseExpires string                `json:"lease_expires,omitempty"`
+	AgeSeconds   int64                 `json:"age_seconds,omitempty"`
+	Activity     string                `json:"activity,omitempty"`
+	Leftovers    []runtimeLockLeftover `json:"leftovers,omitempty"`
+}
+
+// runtimeLockLeftover is a claim, release or reclaim file of the lock
+// protocol. A live contender holds one only for moments; an old one belongs
+// to a process that died mid-protocol.
+type runtimeLockLeftover struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	PID        int    `json:"pid,omitempty"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+// inspectRuntimeLock reads the lock in directory without taking it.
+func inspectRuntimeLock(directory string) (runtimeLockReport, error) {
+	lockPath := filepath.Join(directory, runtimeSetLockName)
+	report := runtimeLockReport{Path: lockPath}
+	status, err := os.Lstat(lockPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return report, err
+	case status.Mode()&os.ModeSymlink != 0 || (!status.Mode().IsRegular() && !status.IsDir()):
+		return report, fmt.Errorf("refusing unsafe package-cache runtime-set lock: %s", lockPath)
+	default:
+		report.Held, report.Shape = true, "file"
+		if status.IsDir() {
+			report.Shape = "legacy directory"
+		}
+		report.AgeSeconds = int64(time.Since(status.ModTime()) / time.Second)
+		if owner, ok := runtimeSetLockOwner(lockPath); ok {
+			report.PID, report.Token = owner.PID, owner.Token
+			report.Host, report.StartTime = owner.Host, owner.StartTime
+			report.Foreign = !runtimeSetOwnerLocal(owner)
+			report.KernelLock = owner.KernelLock != ""
+			report.Alive = !runtimeSetOwnerReclaimable(status, owner, true)
+			report.Activity = describeProgress(owner.Phase, owner.Done, owner.Total)
+			if owner.LeaseExpires > 0 {
+				report.LeaseExpires = time.UnixMilli(owner.LeaseExpires).UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return report, err
+	}
+	for _, entry := range entries {
+		kind, ok := runtimeSetLockProtocolKind(entry.Name())
+		if !ok {
+			continue
+		}
+		leftover := runtimeLockLeftover{Name: entry.Name(), Kind: kind}
+		if info, err := entry.Info(); err == nil {
+			leftover.AgeSeconds = int64(time.Since(info.ModTime()) / time.Second)
+		}
+		if owner, ok := runtimeSetLockOwner(filepath.Join(directory, entry.Name())); ok {
+			leftover.PID = owner.PID
+		}
+		report.Leftovers = append(report.Leftovers, leftover)
+	}
+	return report, nil
+}
+
+// runtimeSetLockProtocolKind names the protocol file a directory entry is.
+func runtimeSetLockProtocolKind(name string) (string, bool) {
+	for _, kind := range []string{"claim", "released", "reclaimed"} {
+		if strings.HasPrefix(name, runtimeSetLockName+"."+kind+"-") {
+			return kind, true
+		}
+	}
+	return "", false
+}
+
+// wrapperLockShow prints the selected version's lock and protocol leftovers.
+func wrapperLockShow(asJSON bool, stdout io.Writer) error {
+	directory, err := selectedRuntimeDirectory()
+	if err != nil {
+		return err
+	}
+	report, err := inspectRuntimeLock(directory)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	fmt.Fprintf(stdout, "lock: %s\n", report.Path)
+	switch {
+	case !report.Held:
+		fmt.Fprintln(stdout, "  not held")
+	case report.PID == 0:
+		fmt.Fprintf(stdout, "  %s with no valid owner record, written %ds ago; reclaimable once %s old\n",
+			report.Shape, report.AgeSeconds, runtimeSetOwnerlessStale)
+	default:
+		state := "not running"
+		switch {
+		case report.Foreign && report.Alive:
+			state = "on another host, boot or PID namespace; lease current"
+		case report.Foreign:
+			state = "on another host, boot or PID namespace; lease expired"
+		case report.Alive:
+			state = "alive"
+		}
+		fmt.Fprintf(stdout, "  owner: process %d (%s)\n", report.PID, state)
+		if report.Host != "" {
+			fmt.Fprintf(stdout, "  host: %s\n", report.Host)
+		}
+		fmt.Fprintf(stdout, "  token: %s\n", report.Token)
+		if report.KernelLock {
+			fmt.Fprintln(stdout, "  kernel lock: held too, and released by the kernel if the owner dies")
+		}
+		if report.LeaseExpires != "" {
+			fmt.Fprintf(stdout, "  lease expires: %s\n", report.LeaseExpires)
+		}
+		fmt.Fprintf(stdout, "  age: %ds since the owner last wrote it\n", report.AgeSeconds)
+		fmt.Fprintf(stdout, "  activity: %s\n", report.Activity)
+	}
+	for _, leftover := range report.Leftovers {
+		owner := ""
+		if leftover.PID != 0 {
+			owner = fmt.Sprintf(", process %d", leftover.PID)
+		}
+		fmt.Fprintf(stdout, "  %s file %s (%ds old%s)\n", leftover.Kind, leftover.Name, leftover.AgeSeconds, owner)
+	}
+	return nil
+}
+
+// wrapperLockBreak reclaims the selected version's lock through the same
+// identity-pinned path a contending launch uses, so it removes exactly the
+// lock it inspected and puts back one that changed hands meanwhile. It
+// refuses while the owner is provably alive; force breaks that owner's lock
+// anyway, and the owner's next ownership check then stops it before it
+// publishes anything. Stale protocol leftovers are removed as well.
+func wrapperLockBreak(force bool, stdout io.Writer) error {
+	directory, err := selectedRuntimeDirectory()
+	if err != nil {
+		return err
+	}
+	if err := requireSafeRuntimeDirectory(directory); os.IsNotExist(err) {
+		fmt.Fprintln(stdout, "lock not held")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	report, err := inspectRuntimeLock(directory)
+	if err != nil {
+		return err
+	}
+	if report.Held {
+		token, err := runtimeSetLockToken()
+		if err != nil {
+			return err
+		}
+		reclaimable := runtimeSetOwnerReclaimable
+		if force {
+			reclaimable = func(_ os.FileInfo, owner runtimeSetLockOwnerRecord, ownerOK bool) bool {
+				if report.Token == "" {
+					return !ownerOK
+				}
+				return ownerOK && owner.Token == report.Token
+			}
+		}
+		if !runtimeSetReclaimLockIf(report.Path, token, reclaimable) {
+			switch {
+			case report.Alive && report.Foreign && !force:
+				return fmt.Errorf(
+					"lock is held by process %d on %s, whose lease has not expired; \"wrapper lock break --force\" breaks it anyway",
+					report.PID, report.Host,
+				)
+			case report.Alive && !force:
+				return fmt.Errorf(
+					"lock is held by live process %d; \"wrapper lock break --force\" breaks it anyway", report.PID,
+				)
+			case report.PID == 0 && !force:
+				return fmt.Errorf(
+					"ownerless lock is younger than %s and may still be being claimed", runtimeSetOwnerlessStale,
+				)
+			default:
+				return fmt.Errorf("lock changed hands while it was being broken; inspect it again")
+			}
+		}
+		switch {
+		case report.PID == 0:
+			fmt.Fprintln(stdout, "broke ownerless lock")
+		case report.Alive:
+			fmt.Fprintf(stdout, "broke lock of live process %d; it stops at its next ownership check\n", report.PID)
+		default:
+			fmt.Fprintf(stdout, "broke lock of process %d, which was not running\n", report.PID)
+		}
+	} else {
+		fmt.Fprintln(stdout, "lock not held")
+	}
+	removed, err := removeStaleRuntimeLockFiles(directory, false)
+	for _, name := range removed {
+		fmt.Fprintf(stdout, "removed %s\n", name)
+	}
+	return err
+}
+
+func selectedRuntimeDirectory() (string, error) {
+	ver, err := selectedVersion()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(binPath(ver)), nil
+}
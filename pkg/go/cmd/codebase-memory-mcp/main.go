@@ -4,6 +4,75 @@
 // GitHub Releases, caches it, and replaces the current process with it.
 // Subsequent runs skip directly to exec.
 //
+// Air-gapped machines can set CBM_GO_ARCHIVE to a pre-downloaded release
+// archive, or to a directory holding it beside checksums.txt; it is verified
+// and cached exactly like a network download. CBM_GO_MIRROR points downloads
+// at an HTTPS release mirror, authenticated by CBM_GO_MIRROR_TOKEN or by the
+// matching machine entry of the netrc file named in CBM_GO_NETRC. For every
+// release the embedded signing-key trust list covers, checksums.txt must also
+// carry a valid minisign signature (checksums.txt.minisig) from a key trusted
+// for that release.
+// CBM_GO_PROVENANCE=require additionally verifies the release's SLSA
+// provenance (checksums.txt.sigstore.json) offline against embedded Sigstore
+// trust roots and records the result beside the cached binary.
+//
+// CBM_GO_VERSION launches a different native release than the one this wrapper
+// was built for; each version is cached side by side, and CBM_GO_MIN_VERSION
+// refuses any selection below a floor. A repository can pin a version and
+// engine tuning defaults for every checkout in .codebase-memory/wrapper.json,
+// and name a mirror that is used only with CBM_GO_ALLOW_REPO_MIRROR=1; a pin
+// that a running daemon would refuse is reported before launch.
+//
+// First-run progress goes to stderr only, never to the MCP stdout:
+// CBM_GO_PROGRESS=tty draws bytes, rate and ETA, ndjson emits one JSON event
+// per line for IDEs and CI, and the default auto draws only on a terminal.
+//
+// "codebase-memory-mcp wrapper cache list|verify|prune" inspects and tidies the
+// wrapper's cache; wrapper commands never reach the native binary. After each
+// install, versions that are neither among the CBM_GO_KEEP_VERSIONS most
+// recently launched (default 3) nor launched within CBM_GO_KEEP_DAYS (default
+// 30) are removed, unless a live process or the running daemon uses them.
+// Versions are cached under an os-arch-variant directory, so a home directory
+// shared between machines holds one runtime set per platform; sets from the
+// older flat layout are moved there on first use.
+//
+// CBM_GO_OFFLINE=1, or {"offline": true} in the machine-wide
+// /etc/codebase-memory-mcp/wrapper-policy.json (%ProgramData% on Windows),
+// forbids every network request: a launch then runs only a cached, reused or
+// CBM_GO_ARCHIVE-provided runtime, and otherwise names the archive and digest
+// to provide. "codebase-memory-mcp wrapper --check" reports whether a launch
+// would need the network.
+//
+// On Linux, CBM_GO_LIBC chooses between the static portable build and the
+// smaller native build that needs glibc 2.38 or newer. The default, auto,
+// takes native only on a glibc host new enough to run it; a release that does
+// not publish the chosen build installs the other one instead.
+//
+// CBM_GO_REUSE=on runs a copy of the release that is already installed, in
+// CBM_GO_REUSE_PATHS, the managed install directory or on PATH, instead of
+// downloading a second one. A copy is used only when its SHA-256 is a binary
+// digest the release publishes and it reports the selected version.
+//
+// "codebase-memory-mcp wrapper doctor [--json]" diagnoses an install end to
+// end: the cache directory and where it came from, its safety, the runtime
+// lock's owner, pending publication journals, the binary, free disk space,
+// the release host through the configured proxy and TLS settings, and the
+// daemon's recent version conflicts. "wrapper repair" shows the publication
+// journals a crash left that a launch cannot resolve on its own, recommends
+// restoring one, keeping the current binary or discarding both, and carries
+// out the chosen plan under the runtime-set lock once confirmed with
+// --yes=STATE, the fingerprint of the journals it showed.
+// "wrapper lock show" names the lock's owner, lease and leftover protocol
+// files, and "wrapper lock break" reclaims it exactly as a contending launch
+// would, refusing a live owner unless --force is given. A lock records its
+// owner's host, boot, PID namespace and start time, so a recycled PID never
+// keeps it, and an owner in another container or on another machine sharing
+// the cache is judged by its lease rather than by a local PID. Where the
+// filesystem supports it, a launch first takes a kernel lock (flock, or
+// LockFileEx on Windows) that queues contenders without polling and that the
+// kernel drops when its owner dies; the link-based lock is still claimed
+// after it, so older wrappers sharing the cache remain excluded.
+//
 // Install:
 //
 //	go install github.com/DeusData/codebase-memory-mcp/pkg/go/cmd/codebase-memory-mcp@latest
@@ -12,24 +81,41 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
+	"cmp"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	_ "embed"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	mathrand "math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -39,24 +125,41 @@ const (
 	version           = "0.8.1"
 	windowsBinaryName = "codebase-memory-mcp.exe"
 
-	maxRedirects            = 5
-	requestTimeout          = 2 * time.Minute
-	connectTimeout          = 15 * time.Second
-	responseHeaderTimeout   = 30 * time.Second
-	candidateTimeout        = 15 * time.Second
-	maxChecksumManifestSize = 1024 * 1024
-	maxReleaseArchiveSize   = int64(256 * 1024 * 1024)
-	maxArchiveMembers       = 64
-	maxArchiveMemberSize    = int64(256 * 1024 * 1024)
-	maxArchiveExpandedSize  = int64(512 * 1024 * 1024)
+	maxRedirects              = 5
+	requestTimeout            = 2 * time.Minute
+	connectTimeout            = 15 * time.Second
+	responseHeaderTimeout     = 30 * time.Second
+	candidateTimeout          = 15 * time.Second
+	maxCandidateVersionOutput = 4096
+	maxChecksumManifestSize   = 1024 * 1024
+	maxChecksumSignatureSize  = 4096
+	maxProvenanceBundleSize   = 1024 * 1024
+	maxReleaseArchiveSize     = int64(256 * 1024 * 1024)
+	maxArchiveMembers         = 64
+	maxArchiveMemberSize      = int64(256 * 1024 * 1024)
+	maxArchiveExpandedSize    = int64(512 * 1024 * 1024)
+	downloadAttempts          = 6
+	downloadMaxSegments       = 32
+	downloadBackoffBase       = 500 * time.Millisecond
+	downloadBackoffMax        = 30 * time.Second
+	downloadRetryAfterMax     = time.Minute
+
+	provenanceBundleSuffix    = ".sigstore.json"
+	provenanceRecordName      = ".cbm-provenance.json"
+	runtimeVerifiedRecordName = ".cbm-runtime-verified.json"
+	partialDownloadPrefix     = ".cbm-partial-"
+	partialValidatorSuffix    = ".validator"
+	maxPartialValidatorSize   = 1024
+	maxRuntimeVerifiedRecord  = 4096
+	githubActionsIssuer       = "https://token.actions.githubusercontent.com"
+	githubHostedBuilderID     = "https://github.com/actions/runner/github-hosted"
 
 	runtimeSetLockName               = ".codebase-memory-mcp-runtime.lock"
-	runtimeSetLockWait               = 45 * time.Second
 	runtimeSetOwnerlessStale         = 30 * time.Second
 	runtimeSetLockLease              = 5 * time.Minute
-	runtimeSetLockHeartbeat          = 20 * time.Second
 	runtimeSetLegacyStale            = time.Hour
 	runtimeSetLockPoll               = 25 * time.Millisecond
+	runtimeSetLockProgressInterval   = 250 * time.Millisecond
 	runtimeSetLockOwnerFile          = "owner.json"
 	runtimeSetLockTokenSize          = 16
 	runtimeBackupPrefix              = ".cbm-runtime-backup-"
@@ -81,15 +184,40 @@ var defaultArchiveResourceLimits = archiveResourceLimits{
 }
 
 type runtimeSetLock struct {
-	path  string
-	token string
-	file  *os.File
+	path     string
+	token    string
+	file     *os.File
+	kernel   *runtimeSetKernelLock
+	progress runtimeSetLockProgress
+	reported time.Time
+	// retired marks a version directory emptied under the lock, whose kernel
+	// lock file the release unlinks before giving up the kernel lock.
+	retired bool
+	// mu serialises the heartbeat's lease renewals with the owner's own
+	// record writes and its release.
+	mu        sync.Mutex
+	heartbeat chan struct{}
+	beating   sync.WaitGroup
+}
+
+// runtimeSetLockProgress is what the lock owner is doing. Advanced moves only
+// when the owner makes progress, so waiters can tell a long download from a
+// stalled owner.
+type runtimeSetLockProgress struct {
+	Phase    string `json:"phase,omitempty"`
+	Done     int64  `json:"done_bytes,omitempty"`
+	Total    int64  `json:"total_bytes,omitempty"`
+	Advanced int64  `json:"progress_ms,omitempty"`
 }
 
 type runtimeSetLockOwnerRecord struct {
 	PID          int    `json:"pid"`
 	Token        string `json:"token"`
 	LeaseExpires int64  `json:"lease_expires_ms,omitempty"`
+	// KernelLock names the kernel lock file the owner also holds, if any.
+	KernelLock string `json:"kernel_lock,omitempty"`
+	runtimeSetOwnerIdentity
+	runtimeSetLockProgress
 }
 
 type runtimeBackupFile struct {
@@ -114,10 +242,79 @@ var (
 	runtimeSetLockOwnerReadObserver func()
 	runtimeBackupCrashObserver      func(string, string) error
 	runtimeSetProcessAlive          = platformRuntimeSetLockProcessAlive
+	runtimeSetProcessStartTime      = platformProcessStartTime
+	runtimeSetLocalHost             = sync.OnceValue(detectRuntimeSetLocalHost)
+	runtimeSetKernelLockAcquire     = acquireRuntimeSetKernelLock
 	runtimeMutationSnapshotCleanup  = os.RemoveAll
+	downloadRetrySleep              = time.Sleep
+	cachedVersionVerifier           = candidateVerifier
+	linuxHostLibc                   = sync.OnceValue(detectLinuxLibc)
+	systemPolicyLocation            = systemPolicyPath
+	wrapperModuleVersion            = sync.OnceValue(readWrapperModuleVersion)
+	// runtimeSetLockWait is how long a waiter tolerates an owner that makes
+	// no progress.
+	runtimeSetLockWait = 45 * time.Second
+	// runtimeSetLockHeartbeat is how often a lock owner renews its lease for
+	// as long as it holds the lock, whether or not it is making progress.
+	runtimeSetLockHeartbeat = 20 * time.Second
 )
 
+// releaseSigningKey is one entry of the trust list for checksums.txt
+// signatures. publicKey is the base64 line of a minisign public key file. A
+// key vouches only for the releases from first through last, which the
+// signed trusted comment names; an unbounded key has no last release yet.
+// The range is in release versions rather than signing times because the
+// signer writes the timestamp: a leaked retired key could backdate one, but
+// the release a launch installs is the wrapper's own choice. Rotation bounds
+// the old key at the last release it signed and appends the successor.
+type releaseSigningKey struct {
+	publicKey string
+	first     releaseVersion
+	last      releaseVersion
+	bounded   bool
+}
+
+// embeddedReleaseChecksums pins the archive digests of the release this
+// wrapper version installs. It is generated by
+// scripts/ci/gen-go-wrapper-checksums.sh before the Go module is tagged, so a
+// tagged go install verifies the archive against data covered by go.sum; the
+// fetched checksums.txt becomes a cross-check. Untagged builds may carry a
+// header with no digests, in which case the fetched manifest is authoritative.
+//
+//go:embed release_checksums.txt
+var embeddedReleaseChecksums []byte
+
+// embeddedReleaseSigningKeys is the trust list for checksums.txt signatures,
+// one "<public key> <first version> [<last version>]" line per key.
+//
+//go:embed release_signing_keys.txt
+var embeddedReleaseSigningKeys []byte
+
+// readWrapperModuleVersion is the module version the Go toolchain stamped into
+// this build: the tag for "go install ...@vX.Y.Z", a pseudo-version for a
+// commit, and "(devel)" for a checkout.
+func readWrapperModuleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return info.Main.Version
+}
+
+// releaseSigningKeys is compiled into the wrapper. Every release at or after
+// the first version any key covers must carry a valid checksums.txt.minisig
+// from a key whose range includes it; only releases older than signing itself
+// are trusted as fetched.
+var releaseSigningKeys, releaseSigningKeysErr = parseReleaseSigningKeys(embeddedReleaseSigningKeys)
+
 func main() {
+	if err := applyRepoConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "codebase-memory-mcp: %v\n", err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "wrapper" {
+		os.Exit(runWrapperCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
 	mutation := runtimeMutationAction(os.Args[1:])
 	if mutation == "update" {
 		fmt.Fprintln(
@@ -126,14 +323,21 @@ func main() {
 		)
 		os.Exit(2)
 	}
-	executable, err := ensureBinary()
+	ver, err := selectedVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codebase-memory-mcp: %v\n", err)
+		os.Exit(1)
+	}
+	// A mutation runs under the lock of the binary's own directory, which
+	// for a reused binary would be another package manager's.
+	executable, err := ensureBinary(ver, mutation == "")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "codebase-memory-mcp: %v\n", err)
 		os.Exit(1)
 	}
 	args := nativeArgs(os.Args[1:])
 	if mutation == "install" || mutation == "uninstall" {
-		err = execBinaryWithRuntimeLock(executable, args)
+		err = execBinaryWithRuntimeLock(executable, ver, args)
 	} else {
 		err = execBinary(executable, args)
 	}
@@ -150,26 +354,61 @@ func main() {
 	}
 }
 
-func ensureBinary() (string, error) {
-	binary := binPath()
-	ready, err := runtimeSetReadyLocked(
-		filepath.Dir(binary), filepath.Base(binary), verifyCandidate,
-	)
-	if err != nil {
+// ensureBinary provisions release ver. Each version is its own runtime set
+// in its own cache directory, with its own lock, so versions sit side by side.
+func ensureBinary(ver string, reuse bool) (string, error) {
+	if err := checkDaemonVersion(ver); err != nil {
 		return "", err
 	}
-	if ready {
+	binary := binPath(ver)
+	markRuntimeLaunch(filepath.Dir(binary))
+	// A set holding a build this host would not install goes through the
+	// locked path, which runs it before trusting it.
+	if runtimeSetVerifiedUnlocked(filepath.Dir(binary), filepath.Base(binary)) &&
+		runtimeSetBuildUsable(filepath.Dir(binary)) {
 		return executionPathForOS(binary, runtime.GOOS), nil
 	}
-	if err := download(binary); err != nil {
+	if reuse {
+		if installed, ok := reuseInstalledBinary(filepath.Dir(binary), ver); ok {
+			return executionPathForOS(installed, runtime.GOOS), nil
+		}
+	}
+	// The lock is held across the download, so concurrent first launches
+	// wait for one install instead of each downloading their own.
+	installed := false
+	if err := installRuntimeSetLocked(
+		filepath.Dir(binary), filepath.Base(binary), candidateVerifier(ver),
+		func(lock *runtimeSetLock) error {
+			installed = true
+			migrated, err := migrateLegacyRuntimeSet(
+				filepath.Join(cacheDir(), ver), filepath.Dir(binary),
+				filepath.Base(binary), candidateVerifier(ver), lock,
+			)
+			if err == nil && migrated {
+				return nil
+			}
+			return download(binary, ver, lock)
+		},
+	); err != nil {
 		return "", err
 	}
+	if installed {
+		// A fresh install is when an older version most likely became
+		// unused, and the launch has already paid for a slow start.
+		removed, err := collectRuntimeGarbage(runtimeCacheRoot())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "codebase-memory-mcp: cache cleanup skipped: %v\n", err)
+		}
+		for _, ver := range removed {
+			fmt.Fprintf(os.Stderr, "codebase-memory-mcp: removed unused cached v%s\n", ver)
+		}
+	}
 	return executionPathForOS(binary, runtime.GOOS), nil
 }
 
-func binPath() string {
+func binPath(ver string) string {
 	return filepath.Join(
-		cacheDir(), version, binaryNameForOS(runtime.GOOS),
+		runtimeCacheRoot(), ver, binaryNameForOS(runtime.GOOS),
 	)
 }
 
@@ -300,26 +539,32 @@ func mutationSnapshotEnvironment() []string {
 }
 
 func cacheDir() string {
+	directory, _ := cacheDirWithSource()
+	return directory
+}
+
+// cacheDirWithSource also names what chose the cache directory.
+func cacheDirWithSource() (string, string) {
 	if d := os.Getenv("CBM_CACHE_DIR"); d != "" {
-		return d
+		return d, "CBM_CACHE_DIR"
 	}
 	switch runtime.GOOS {
 	case "windows":
 		if d := os.Getenv("LOCALAPPDATA"); d != "" {
-			return filepath.Join(d, "codebase-memory-mcp")
+			return filepath.Join(d, "codebase-memory-mcp"), "LOCALAPPDATA"
 		}
 	case "darwin":
 		if home, err := os.UserHomeDir(); err == nil {
-			return filepath.Join(home, "Library", "Caches", "codebase-memory-mcp")
+			return filepath.Join(home, "Library", "Caches", "codebase-memory-mcp"), "home directory"
 		}
 	}
 	if d := os.Getenv("XDG_CACHE_HOME"); d != "" {
-		return filepath.Join(d, "codebase-memory-mcp")
+		return filepath.Join(d, "codebase-memory-mcp"), "XDG_CACHE_HOME"
 	}
 	if home, err := os.UserHomeDir(); err == nil {
-		return filepath.Join(home, ".cache", "codebase-memory-mcp")
+		return filepath.Join(home, ".cache", "codebase-memory-mcp"), "home directory"
 	}
-	return filepath.Join(os.TempDir(), "codebase-memory-mcp")
+	return filepath.Join(os.TempDir(), "codebase-memory-mcp"), "temporary directory"
 }
 
 func goos() string {
@@ -359,26 +604,69 @@ func archiveNamesForOS(platform, binaryName string) []string {
 	}
 }
 
-func download(dest string) error {
-	platform := goos()
-	arch := goarch()
+// wrapperSetting returns a wrapper-only setting. Native CBM_* settings are
+// passed through untouched; these only change how the wrapper provisions the
+// native runtime.
+func wrapperSetting(name string) string {
+	value, _ := wrapperSettingSource(name)
+	return value
+}
+
+// releaseArchiveName is the release asset for a platform build. Only the
+// portable Linux build carries its variant in the name; the native one is
+// plain linux-<arch>.
+func releaseArchiveName(platform, arch, variant string) string {
 	ext := "tar.gz"
 	if platform == "windows" {
 		ext = "zip"
 	}
-
-	portable := ""
-	if platform == "linux" {
-		portable = "-portable"
+	suffix := ""
+	if platform == "linux" && variant == linuxVariantPortable {
+		suffix = "-portable"
 	}
-	archive := fmt.Sprintf(
-		"codebase-memory-mcp-%s-%s%s.%s",
-		platform, arch, portable, ext,
+	return fmt.Sprintf(
+		"codebase-memory-mcp-%s-%s%s.%s", platform, arch, suffix, ext,
 	)
-	url := fmt.Sprintf("https://github.com/%s/releases/download/v%s/%s", repo, version, archive)
-	checksumURL := fmt.Sprintf("https://github.com/%s/releases/download/v%s/checksums.txt", repo, version)
+}
+
+func download(dest, ver string, lock *runtimeSetLock) error {
+	return downloadWithVerifierAndLock(dest, ver, candidateVerifier(ver), lock)
+}
 
-	fmt.Fprintf(os.Stderr, "codebase-memory-mcp: downloading v%s for %s/%s...\n", version, platform, arch)
+func downloadWithVerifier(dest string, verifier func(string) error) error {
+	return downloadWithVerifierAndLock(dest, version, verifier, nil)
+}
+
+// downloadWithVerifierAndLock installs the runtime set of release ver. With a held
+// runtime-set lock it reports progress through the lock record and publishes
+// without releasing the lock; without one, publication takes the lock itself.
+func downloadWithVerifierAndLock(
+	dest, ver string, verifier func(string) error, lock *runtimeSetLock,
+) (err error) {
+	reporter := newProgressReporter(progressMode(os.Stderr), os.Stderr)
+	defer func() { reporter.finish(err) }()
+	progress := func(phase string, done, total int64) {
+		reporter.update(phase, done, total)
+		if lock != nil {
+			// A lost lock is caught by the ownership checks of publication.
+			_ = reportRuntimeSetLockProgress(lock, phase, done, total)
+		}
+	}
+	platform := goos()
+	arch := goarch()
+	variants, err := linuxArchiveVariants()
+	if err != nil {
+		return err
+	}
+	archives := make([]string, len(variants))
+	for index, variant := range variants {
+		archives[index] = releaseArchiveName(platform, arch, variant)
+	}
+	var archive string
+	ext := "tar.gz"
+	if platform == "windows" {
+		ext = "zip"
+	}
 
 	tmp, err := os.MkdirTemp("", "cbm-install-*")
 	if err != nil {
@@ -387,24 +675,138 @@ func download(dest string) error {
 	defer os.RemoveAll(tmp)
 
 	archivePath := filepath.Join(tmp, "cbm."+ext)
-	if err := httpGet(url, archivePath); err != nil {
-		return fmt.Errorf("download failed: %w", err)
+	var checksums map[string]string
+	var archiveDigest, manifestDigest [sha256.Size]byte
+	var provenanceBundle []byte
+	requireProvenance, err := provenanceRequired()
+	if err != nil {
+		return err
 	}
+	if local := wrapperSetting("CBM_GO_ARCHIVE"); local != "" {
+		localArchive, localChecksums, err := localReleaseFiles(local, archives)
+		if err != nil {
+			return err
+		}
+		archive = filepath.Base(localArchive)
+		reporter.begin(ver, platform, arch, localArchive)
+		archiveDigest, err = copyLocalArchive(localArchive, archivePath, maxReleaseArchiveSize)
+		if err != nil {
+			return fmt.Errorf("local release archive unavailable: %w", err)
+		}
+		checksums, manifestDigest, err = readChecksumManifest(localChecksums, ver)
+		if err != nil {
+			return fmt.Errorf("checksum manifest unavailable: %w", err)
+		}
+		if requireProvenance {
+			provenanceBundle, err = readLocalDocument(
+				localChecksums+provenanceBundleSuffix, maxProvenanceBundleSize,
+			)
+			if err != nil {
+				return fmt.Errorf("provenance attestation unavailable: %w", err)
+			}
+		}
+	} else {
+		offline, policySource, err := offlinePolicy()
+		if err != nil {
+			return err
+		}
+		if offline {
+			return offlineInstallError(ver, archives[0], policySource)
+		}
+		source, err := releaseSourceFromSettings()
+		if err != nil {
+			return err
+		}
+		if err := configureReleaseNetwork(); err != nil {
+			return err
+		}
+		checksumURL := source.assetURL(ver, "checksums.txt")
 
-	// A release binary is executable input, so checksum verification is a
-	// mandatory precondition rather than a best-effort warning.
-	checksums, err := fetchChecksums(checksumURL)
-	if err != nil {
-		return fmt.Errorf("checksum manifest unavailable: %w", err)
+		reporter.begin(ver, platform, arch, "")
+
+		// A release binary is executable input, so checksum verification is a
+		// mandatory precondition rather than a best-effort warning. The
+		// manifest is fetched first because it also says which of this
+		// platform's builds the release publishes.
+		checksums, manifestDigest, err = fetchChecksums(checksumURL, ver, source.credential)
+		if err != nil {
+			return fmt.Errorf("checksum manifest unavailable: %w", err)
+		}
+		archive = chooseArchive(archives, checksums)
+		expected, err := expectedArchiveDigest(checksums, ver, archive)
+		if err != nil {
+			return err
+		}
+
+		// Under the lock the archive downloads into the version directory,
+		// so a launch that crashes or is interrupted leaves bytes the next
+		// one resumes. Without it, concurrent installers would share them.
+		fetch := httpGet
+		if lock != nil {
+			name := partialDownloadName(archive, expected)
+			removeStalePartialDownloads(filepath.Dir(dest), name)
+			archivePath = filepath.Join(filepath.Dir(dest), name)
+			fetch = httpGetResumable
+		}
+		archiveDigest, err = fetch(
+			source.assetURL(ver, archive), source.credential, archivePath,
+			func(done, total int64) { progress("download", done, total) },
+		)
+		if err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		// Complete bytes are never resumed: they either verify and install
+		// or are not the archive.
+		defer func() { _ = removePartialDownload(archivePath) }()
+		if requireProvenance {
+			provenanceBundle, err = fetchReleaseDocument(
+				checksumURL+provenanceBundleSuffix, source.credential,
+				maxProvenanceBundleSize,
+			)
+			if err != nil {
+				return fmt.Errorf("provenance attestation unavailable: %w", err)
+			}
+		}
 	}
-	expected, ok := checksums[archive]
-	if !ok {
-		return fmt.Errorf("checksum manifest has no entry for %s", archive)
+	if archive != archives[0] {
+		reporter.note(fmt.Sprintf(
+			"v%s has no %s; installing %s instead", ver, archives[0], archive,
+		))
+	}
+	progress("checksum", 0, 0)
+	expected, err := expectedArchiveDigest(checksums, ver, archive)
+	if err != nil {
+		return err
 	}
-	if err := verifyChecksum(archivePath, expected); err != nil {
+	// The digest was computed while the archive was written, so the bytes are
+	// never read back just to be hashed.
+	if err := verifyDigest(archiveDigest, expected); err != nil {
 		return err
 	}
+	provenance := provenanceRecord{
+		Status:        "not-checked",
+		Version:       ver,
+		Archive:       archive,
+		ArchiveSHA256: expected,
+	}
+	if requireProvenance {
+		// The release attests checksums.txt, which binds the archive digest
+		// just verified; an attestation over the archive itself is accepted
+		// too.
+		provenance, err = verifyReleaseProvenance(
+			provenanceBundle, embeddedSigstoreTrustedRoot,
+			hex.EncodeToString(archiveDigest[:]),
+			hex.EncodeToString(manifestDigest[:]),
+		)
+		if err != nil {
+			return fmt.Errorf("provenance verification failed: %w", err)
+		}
+		provenance.Version = ver
+		provenance.Archive = archive
+		provenance.ArchiveSHA256 = expected
+	}
 
+	progress("extract", 0, 0)
 	binName := binaryNameForOS(platform)
 	archiveNames := archiveNamesForOS(platform, binName)
 	extractNames := []string{binName}
@@ -435,84 +837,107 @@ func download(dest string) error {
 			return fmt.Errorf("could not set candidate permissions for %s: %w", name, err)
 		}
 	}
-	if err := verifyCandidate(filepath.Join(tmp, binName)); err != nil {
-		return err
+	progress("verify", 0, 0)
+	if verifier != nil {
+		if err := verifier(filepath.Join(tmp, binName)); err != nil {
+			return err
+		}
 	}
 
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return fmt.Errorf("could not create cache dir: %w", err)
 	}
 
-	if err := publishRuntimeSetWithRecovery(
-		tmp, filepath.Dir(dest), binName, verifyCandidate,
-	); err != nil {
+	progress("publish", 0, 0)
+	if lock != nil {
+		err = publishRuntimeSetLocked(
+			tmp, filepath.Dir(dest), binName, verifier, os.Rename, lock,
+		)
+	} else {
+		err = publishRuntimeSetWithRecovery(
+			tmp, filepath.Dir(dest), binName, verifier,
+		)
+	}
+	if err != nil {
 		return fmt.Errorf("could not install runtime set: %w", err)
 	}
+	if err := writeJSONFileAtomic(
+		filepath.Join(filepath.Dir(dest), provenanceRecordName), provenance,
+	); err != nil {
+		return fmt.Errorf("could not record provenance result: %w", err)
+	}
 
 	return nil
 }
 
-// validateURLScheme rejects non-https URLs before any fetch (defense-in-depth).
-func validateURLScheme(rawURL string) error {
-	parsed, err := url.Parse(rawURL)
-	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
-		return fmt.Errorf("refusing non-https URL: %s", rawURL)
+// localReleaseFiles resolves CBM_GO_ARCHIVE for air-gapped installs. It names
+// either a platform archive itself, with checksums.txt beside it, or a
+// directory holding both exactly as they appear on the release page. archives
+// are this platform's builds, preferred first; a directory supplies the first
+// one it holds. CBM_GO_CHECKSUMS overrides the manifest location.
+func localReleaseFiles(location string, archives []string) (string, string, error) {
+	archivePath := location
+	status, err := os.Stat(location)
+	if err != nil {
+		return "", "", fmt.Errorf("local release archive unavailable: %w", err)
 	}
-	return nil
-}
-
-// httpsOnlyClient returns an HTTP client that rejects non-HTTPS redirects.
-var httpsOnlyClient = &http.Client{
-	Timeout: requestTimeout,
-	Transport: &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: connectTimeout}).DialContext,
-		ForceAttemptHTTP2:     true,
-		TLSHandshakeTimeout:   connectTimeout,
-		ResponseHeaderTimeout: responseHeaderTimeout,
-		ExpectContinueTimeout: time.Second,
-	},
-	CheckRedirect: func(req *http.Request, via []*http.Request) error {
-		if err := validateURLScheme(req.URL.String()); err != nil {
-			return fmt.Errorf("refusing unsafe redirect: %w", err)
-		}
-		if len(via) > maxRedirects {
-			return fmt.Errorf("too many redirects")
+	if status.IsDir() {
+		archivePath = filepath.Join(location, archives[0])
+		for _, archive := range archives {
+			if _, err := os.Stat(filepath.Join(location, archive)); err == nil {
+				archivePath = filepath.Join(location, archive)
+				break
+			}
 		}
-		return nil
-	},
-}
-
-func httpGet(rawURL, dest string) error {
-	return httpGetWithLimit(rawURL, dest, maxReleaseArchiveSize)
+	} else if !slices.Contains(archives, filepath.Base(location)) {
+		return "", "", fmt.Errorf(
+			"local release archive %s does not match this platform's %s",
+			filepath.Base(location), archives[0],
+		)
+	}
+	checksumPath := wrapperSetting("CBM_GO_CHECKSUMS")
+	if checksumPath == "" {
+		checksumPath = filepath.Join(filepath.Dir(archivePath), "checksums.txt")
+	}
+	return archivePath, checksumPath, nil
 }
 
-func httpGetWithLimit(rawURL, dest string, maxBytes int64) error {
-	if err := validateURLScheme(rawURL); err != nil {
-		return err
-	}
+// copyLocalArchive snapshots a local archive into the private install
+// directory so verification and extraction read the same bytes. It returns
+// the SHA-256 of the copied bytes.
+func copyLocalArchive(
+	source, dest string, maxBytes int64,
+) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
 	if maxBytes <= 0 {
-		return fmt.Errorf("invalid compressed archive safety limit")
+		return digest, fmt.Errorf("invalid compressed archive safety limit")
 	}
-	resp, err := httpsOnlyClient.Get(rawURL) //nolint:gosec
+	input, err := os.Open(source)
 	if err != nil {
-		return err
+		return digest, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d for %s", resp.StatusCode, rawURL)
+	defer input.Close()
+	status, err := input.Stat()
+	if err != nil {
+		return digest, err
 	}
-	if resp.ContentLength > maxBytes {
-		return fmt.Errorf(
+	if !status.Mode().IsRegular() {
+		return digest, fmt.Errorf("release archive is not a regular file")
+	}
+	if status.Size() > maxBytes {
+		return digest, fmt.Errorf(
 			"release archive exceeds the %d-byte compressed safety limit",
 			maxBytes,
 		)
 	}
-	f, err := os.Create(dest)
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		return err
+		return digest, err
 	}
-	written, copyErr := io.Copy(f, io.LimitReader(resp.Body, maxBytes+1))
+	hash := sha256.New()
+	written, copyErr := io.Copy(
+		io.MultiWriter(f, hash), io.LimitReader(input, maxBytes+1),
+	)
 	closeErr := f.Close()
 	if copyErr == nil && written > maxBytes {
 		copyErr = fmt.Errorf(
@@ -520,77 +945,1145 @@ func httpGetWithLimit(rawURL, dest string, maxBytes int64) error {
 			maxBytes,
 		)
 	}
+	if copyErr == nil {
+		copyErr = closeErr
+	}
 	if copyErr != nil {
 		_ = os.Remove(dest)
-		return copyErr
+		return digest, copyErr
 	}
-	if closeErr != nil {
-		_ = os.Remove(dest)
+	copy(digest[:], hash.Sum(nil))
+	return digest, nil
+}
+
+// validateURLScheme rejects non-https URLs before any fetch (defense-in-depth).
+func validateURLScheme(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+		return fmt.Errorf("refusing non-https URL: %s", rawURL)
 	}
-	return closeErr
+	return nil
 }
 
-func fetchChecksums(url string) (map[string]string, error) {
-	if err := validateURLScheme(url); err != nil {
-		return nil, err
+// releaseSource is where release assets are fetched from. The default is the
+// GitHub release page; CBM_GO_MIRROR replaces its
+// https://github.com/<repo>/releases/download prefix with an HTTPS mirror that
+// keeps the same v<version>/<asset> layout, such as an Artifactory or Nexus
+// remote repository or a GitHub Enterprise release page.
+type releaseSource struct {
+	base       string
+	credential *releaseCredential
+}
+
+// releaseCredential authorizes requests to exactly one mirror host. It is
+// never attached to the public default source or to any redirect target on a
+// different host.
+type releaseCredential struct {
+	host          string
+	authorization string
+}
+
+func releaseSourceFromSettings() (releaseSource, error) {
+	defaultBase := fmt.Sprintf("https://github.com/%s/releases/download", repo)
+	mirror, mirrorSource := wrapperSettingSource("CBM_GO_MIRROR")
+	if mirror == "" {
+		return releaseSource{base: defaultBase}, nil
+	}
+	mirror = strings.TrimRight(mirror, "/")
+	if err := validateURLScheme(mirror); err != nil {
+		return releaseSource{}, fmt.Errorf("invalid CBM_GO_MIRROR: %w", err)
 	}
-	resp, err := httpsOnlyClient.Get(url) //nolint:gosec
+	parsed, err := url.Parse(mirror)
 	if err != nil {
-		return nil, err
+		return releaseSource{}, fmt.Errorf("invalid CBM_GO_MIRROR: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return releaseSource{}, fmt.Errorf(
+			"invalid CBM_GO_MIRROR: a release mirror base cannot carry a query or fragment",
+		)
+	}
+	source := releaseSource{base: mirror}
+	host := canonicalReleaseHost(parsed)
+	if token := wrapperSetting("CBM_GO_MIRROR_TOKEN"); token != "" {
+		// The token was issued for the mirror the user chose, not for one
+		// named by a repository's checked-in configuration.
+		if mirrorSource != "CBM_GO_MIRROR" {
+			return releaseSource{}, fmt.Errorf(
+				"CBM_GO_MIRROR_TOKEN is only sent to a mirror set in CBM_GO_MIRROR, not to %s from %s; use CBM_GO_NETRC for it instead",
+				parsed.Hostname(), mirrorSource,
+			)
+		}
+		source.credential = &releaseCredential{
+			host: host, authorization: "Bearer " + token,
+		}
+	} else if netrcPath := wrapperSetting("CBM_GO_NETRC"); netrcPath != "" {
+		login, password, found, err := netrcLogin(netrcPath, parsed.Hostname())
+		if err != nil {
+			return releaseSource{}, fmt.Errorf("could not read CBM_GO_NETRC: %w", err)
+		}
+		if !found {
+			return releaseSource{}, fmt.Errorf(
+				"CBM_GO_NETRC has no machine entry for release mirror %s",
+				parsed.Hostname(),
+			)
+		}
+		source.credential = &releaseCredential{
+			host: host,
+			authorization: "Basic " + base64.StdEncoding.EncodeToString(
+				[]byte(login+":"+password),
+			),
+		}
 	}
-	body, err := io.ReadAll(io.LimitReader(resp.Body, maxChecksumManifestSize+1))
+	return source, nil
+}
+
+func (source releaseSource) assetURL(releaseVersion, name string) string {
+	return source.base + "/v" + url.PathEscape(releaseVersion) + "/" + url.PathEscape(name)
+}
+
+// canonicalReleaseHost names a host with its effective port so
+// "mirror.example" and "mirror.example:443" match while another port does not.
+func canonicalReleaseHost(target *url.URL) string {
+	port := target.Port()
+	if port == "" {
+		port = "443"
+	}
+	return strings.ToLower(target.Hostname()) + ":" + port
+}
+
+// netrcLogin returns the login and password of the netrc machine entry for
+// host. A "default" entry is deliberately ignored: credentials are only ever
+// released to the explicitly configured mirror.
+func netrcLogin(path, host string) (string, string, bool, error) {
+	status, err := os.Stat(path)
 	if err != nil {
-		return nil, err
+		return "", "", false, err
 	}
-	if len(body) > maxChecksumManifestSize {
-		return nil, fmt.Errorf("checksums.txt exceeds the 1 MiB safety limit")
+	if !status.Mode().IsRegular() || status.Size() > maxChecksumManifestSize {
+		return "", "", false, fmt.Errorf("netrc is not a small regular file: %s", path)
 	}
-	result := make(map[string]string)
-	for _, line := range strings.Split(string(body), "\n") {
-		parts := strings.Fields(line)
-		if len(parts) == 2 {
-			name := parts[1]
-			if strings.HasPrefix(name, "*") {
-				name = name[1:]
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	var tokens []string
+	inMacro := false
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if inMacro {
+			// A macro body runs until the next empty line.
+			inMacro = len(fields) != 0
+			continue
+		}
+		for _, field := range fields {
+			if field == "macdef" {
+				inMacro = true
+				break
 			}
-			digest := strings.ToLower(parts[0])
-			if len(digest) != sha256.Size*2 {
-				return nil, fmt.Errorf("invalid SHA-256 checksum for %s", name)
+			tokens = append(tokens, field)
+		}
+	}
+	var login, password string
+	matched := false
+	for index := 0; index < len(tokens); index++ {
+		switch tokens[index] {
+		case "machine", "default":
+			if matched {
+				return login, password, true, nil
 			}
-			if _, err := hex.DecodeString(digest); err != nil {
-				return nil, fmt.Errorf("invalid SHA-256 checksum for %s: %w", name, err)
+			if tokens[index] == "machine" && index+1 < len(tokens) {
+				index++
+				matched = strings.EqualFold(tokens[index], host)
 			}
-			if prior, exists := result[name]; exists && prior != digest {
-				return nil, fmt.Errorf("conflicting SHA-256 checksums for %s", name)
+		case "login", "password", "account":
+			if index+1 >= len(tokens) {
+				break
+			}
+			index++
+			if matched && tokens[index-1] == "login" {
+				login = tokens[index]
+			} else if matched && tokens[index-1] == "password" {
+				password = tokens[index]
 			}
-			result[name] = digest
 		}
 	}
-	return result, nil
+	return login, password, matched, nil
 }
 
-func verifyChecksum(path, expected string) error {
-	expected = strings.ToLower(expected)
-	if len(expected) != sha256.Size*2 {
-		return fmt.Errorf("invalid SHA-256 checksum length")
-	}
-	if _, err := hex.DecodeString(expected); err != nil {
-		return fmt.Errorf("invalid SHA-256 checksum: %w", err)
+func newReleaseRequest(
+	rawURL string, credential *releaseCredential,
+) (*http.Request, error) {
+	if err := validateURLScheme(rawURL); err != nil {
+		return nil, err
 	}
-	f, err := os.Open(path)
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if credential != nil && credential.authorization != "" &&
+		canonicalReleaseHost(request.URL) == credential.host {
+		request.Header.Set("Authorization", credential.authorization)
+	}
+	return request, nil
+}
+
+// checkReleaseRedirect keeps every redirect on HTTPS and drops mirror
+// credentials as soon as a hop leaves the host they were issued for. Go's own
+// policy would still forward them to subdomains.
+func checkReleaseRedirect(req *http.Request, via []*http.Request) error {
+	if err := validateURLScheme(req.URL.String()); err != nil {
+		return fmt.Errorf("refusing unsafe redirect: %w", err)
+	}
+	if len(via) > maxRedirects {
+		return fmt.Errorf("too many redirects")
+	}
+	if len(via) > 0 &&
+		canonicalReleaseHost(req.URL) != canonicalReleaseHost(via[0].URL) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// httpsOnlyClient returns an HTTP client that rejects non-HTTPS redirects.
+var httpsOnlyClient = newHTTPSOnlyClient(newReleaseTransport())
+
+func newReleaseTransport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+func newHTTPSOnlyClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Timeout:       requestTimeout,
+		Transport:     transport,
+		CheckRedirect: checkReleaseRedirect,
+	}
+}
+
+// configureReleaseNetwork applies enterprise network settings to
+// httpsOnlyClient before the first release request:
+//
+//   - CBM_GO_CA_BUNDLE lists PEM CA bundles, separated like PATH, that are
+//     trusted in addition to the system roots (TLS-intercepting proxies).
+//   - CBM_GO_CLIENT_CERT and CBM_GO_CLIENT_KEY present a client certificate to
+//     mTLS-only mirrors. The key defaults to the certificate file.
+//   - CBM_GO_PROXY replaces the *_PROXY environment with one explicit
+//     http, https, socks5 or socks5h proxy URL, or "direct" for none.
+//
+// The minimum TLS version and the redirect policy are unchanged.
+func configureReleaseNetwork() error {
+	if err := requireNetworkAllowed(); err != nil {
+		return err
+	}
+	transport, custom, err := releaseTransportFromSettings()
+	if err != nil {
+		return err
+	}
+	if custom {
+		httpsOnlyClient = newHTTPSOnlyClient(transport)
+	}
+	return nil
+}
+
+func releaseTransportFromSettings() (*http.Transport, bool, error) {
+	transport := newReleaseTransport()
+	custom := false
+	if bundles := wrapperSetting("CBM_GO_CA_BUNDLE"); bundles != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		for _, bundle := range filepath.SplitList(bundles) {
+			if bundle == "" {
+				continue
+			}
+			contents, err := os.ReadFile(bundle)
+			if err != nil {
+				return nil, false, fmt.Errorf("could not read CBM_GO_CA_BUNDLE: %w", err)
+			}
+			if !roots.AppendCertsFromPEM(contents) {
+				return nil, false, fmt.Errorf(
+					"CBM_GO_CA_BUNDLE file has no PEM certificates: %s", bundle,
+				)
+			}
+		}
+		releaseTLSConfig(transport).RootCAs = roots
+		custom = true
+	}
+	certificate := wrapperSetting("CBM_GO_CLIENT_CERT")
+	key := wrapperSetting("CBM_GO_CLIENT_KEY")
+	if certificate == "" && key != "" {
+		return nil, false, fmt.Errorf("CBM_GO_CLIENT_KEY requires CBM_GO_CLIENT_CERT")
+	}
+	if certificate != "" {
+		if key == "" {
+			key = certificate
+		}
+		pair, err := tls.LoadX509KeyPair(certificate, key)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not load release client certificate: %w", err)
+		}
+		releaseTLSConfig(transport).Certificates = []tls.Certificate{pair}
+		custom = true
+	}
+	if proxy := wrapperSetting("CBM_GO_PROXY"); proxy != "" {
+		if strings.EqualFold(proxy, "direct") {
+			transport.Proxy = nil
+		} else {
+			proxyURL, err := url.Parse(proxy)
+			if err != nil || proxyURL.Host == "" {
+				// The value is left out: a proxy URL missing its scheme
+				// parses without a host and would print its password.
+				return nil, false, fmt.Errorf(
+					"invalid CBM_GO_PROXY: use scheme://[user:password@]host:port or direct",
+				)
+			}
+			switch proxyURL.Scheme {
+			case "http", "https", "socks5", "socks5h":
+			default:
+				return nil, false, fmt.Errorf(
+					"unsupported CBM_GO_PROXY scheme %q: use http, https, socks5 or socks5h",
+					proxyURL.Scheme,
+				)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		custom = true
+	}
+	return transport, custom, nil
+}
+
+func releaseTLSConfig(transport *http.Transport) *tls.Config {
+	if transport.TLSClientConfig == nil {
+		// Go's client default; stated so custom trust never lowers it.
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return transport.TLSClientConfig
+}
+
+func httpGet(
+	rawURL string, credential *releaseCredential, dest string,
+	progress func(done, total int64),
+) ([sha256.Size]byte, error) {
+	return httpGetWithProgress(
+		rawURL, credential, dest, maxReleaseArchiveSize, progress,
+	)
+}
+
+// releaseDownload is the resumable state of one archive download. It spans
+// every retry of a single launch: an interrupted transfer resumes with an
+// HTTP Range request pinned by If-Range to the representation it started
+// with, and the compressed-size limit covers the sum of all segments. The
+// hash always covers exactly the bytes on disk, so a completed download is
+// verified without reading the archive back. A persistent download also
+// spans launches: its bytes and validator stay on disk when it is given up
+// on, and the next launch picks them up.
+type releaseDownload struct {
+	url        string
+	credential *releaseCredential
+	dest       string
+	maxBytes   int64
+	file       *os.File
+	hash       hash.Hash
+	written    int64
+	total      int64
+	validator  string
+	progress   func(done, total int64)
+	persistent bool
+	resumable  bool
+}
+
+func httpGetWithLimit(
+	rawURL string, credential *releaseCredential, dest string, maxBytes int64,
+) ([sha256.Size]byte, error) {
+	return httpGetWithProgress(rawURL, credential, dest, maxBytes, nil)
+}
+
+func httpGetWithProgress(
+	rawURL string, credential *releaseCredential, dest string, maxBytes int64,
+	progress func(done, total int64),
+) ([sha256.Size]byte, error) {
+	download := &releaseDownload{
+		url: rawURL, credential: credential, dest: dest, maxBytes: maxBytes,
+		hash: sha256.New(), progress: progress,
+	}
+	return download.run()
+}
+
+// httpGetResumable is httpGet into a partial file that outlives the launch.
+// Bytes already in partial, from a launch that crashed, was interrupted or
+// gave up on the network, are hashed and resumed with their validator. The
+// partial survives only a download abandoned on transient failures; the
+// caller removes it once the download completes.
+func httpGetResumable(
+	rawURL string, credential *releaseCredential, partial string,
+	progress func(done, total int64),
+) ([sha256.Size]byte, error) {
+	download := &releaseDownload{
+		url: rawURL, credential: credential, dest: partial,
+		maxBytes: maxReleaseArchiveSize, hash: sha256.New(), progress: progress,
+		persistent: true,
+	}
+	if err := download.resume(); err != nil {
+		_ = download.discardPartial()
+		return [sha256.Size]byte{}, err
+	}
+	return download.run()
+}
+
+func (download *releaseDownload) run() (digest [sha256.Size]byte, result error) {
+	if _, err := newReleaseRequest(download.url, download.credential); err != nil {
+		return digest, err
+	}
+	if download.maxBytes <= 0 {
+		return digest, fmt.Errorf("invalid compressed archive safety limit")
+	}
+	defer func() {
+		if download.file == nil {
+			return
+		}
+		closeErr := download.file.Close()
+		if result == nil {
+			result = closeErr
+		}
+		if result != nil && !(download.persistent && download.resumable) {
+			_ = download.discardPartial()
+		}
+	}()
+	failures := 0
+	for segment := 1; ; segment++ {
+		before := download.written
+		retry, wait, err := download.fetchSegment()
+		if err == nil {
+			copy(digest[:], download.hash.Sum(nil))
+			return digest, nil
+		}
+		if !retry {
+			return digest, err
+		}
+		if download.written > before {
+			failures = 0
+		} else {
+			failures++
+		}
+		if failures >= downloadAttempts || segment >= downloadMaxSegments {
+			download.resumable = true
+			return digest, fmt.Errorf("giving up after %d attempts: %w", segment, err)
+		}
+		if wait <= 0 {
+			wait = downloadBackoff(failures)
+		}
+		downloadRetrySleep(wait)
+	}
+}
+
+// fetchSegment requests the bytes after those already written. It reports
+// whether a failure is worth retrying and any server-requested delay.
+func (download *releaseDownload) fetchSegment() (bool, time.Duration, error) {
+	request, err := newReleaseRequest(download.url, download.credential)
+	if err != nil {
+		return false, 0, err
+	}
+	if download.written > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", download.written))
+		if download.validator != "" {
+			request.Header.Set("If-Range", download.validator)
+		}
+	}
+	resp, err := httpsOnlyClient.Do(request) //nolint:gosec
+	if err != nil {
+		return retryableDownloadError(err), 0, err
+	}
+	defer resp.Body.Close()
+	statusErr := fmt.Errorf("HTTP %d for %s", resp.StatusCode, download.url)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// A full response replaces any partial bytes, including when the
+		// representation changed and If-Range declined the resume.
+		if resp.ContentLength > download.maxBytes {
+			return false, 0, download.limitError()
+		}
+		if err := download.restart(); err != nil {
+			return false, 0, err
+		}
+		download.validator = resumeValidator(resp.Header)
+		download.total = max(resp.ContentLength, 0)
+		if err := download.saveValidator(); err != nil {
+			return false, 0, err
+		}
+	case resp.StatusCode == http.StatusPartialContent && download.written > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != download.written {
+			if err := download.restart(); err != nil {
+				return false, 0, err
+			}
+			return true, 0, fmt.Errorf("unusable partial response for %s", download.url)
+		}
+		if total > download.maxBytes {
+			return false, 0, download.limitError()
+		}
+		download.total = max(total, 0)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable &&
+		download.written > 0:
+		if err := download.restart(); err != nil {
+			return false, 0, err
+		}
+		return true, 0, statusErr
+	case retryableDownloadStatus(resp.StatusCode):
+		return true, retryAfterDelay(resp.Header.Get("Retry-After")), statusErr
+	default:
+		return false, 0, statusErr
+	}
+	_, copyErr := io.Copy(
+		download,
+		io.LimitReader(resp.Body, download.maxBytes-download.written+1),
+	)
+	if download.written > download.maxBytes {
+		return false, 0, download.limitError()
+	}
+	if copyErr != nil {
+		return retryableDownloadError(copyErr), 0, copyErr
+	}
+	return false, 0, nil
+}
+
+// Write appends to the archive, hashes the same bytes and reports progress,
+// so all three always agree on how much of the archive has arrived.
+func (download *releaseDownload) Write(data []byte) (int, error) {
+	n, err := download.file.Write(data)
+	download.hash.Write(data[:n])
+	download.written += int64(n)
+	if download.progress != nil {
+		download.progress(download.written, download.total)
+	}
+	return n, err
+}
+
+func (download *releaseDownload) restart() error {
+	download.written = 0
+	download.validator = ""
+	download.hash.Reset()
+	if download.persistent {
+		if err := os.Remove(download.dest + partialValidatorSuffix); err != nil &&
+			!os.IsNotExist(err) {
+			return err
+		}
+	}
+	if download.file == nil {
+		file, err := os.Create(download.dest)
+		if err != nil {
+			return err
+		}
+		download.file = file
+		return nil
+	}
+	if err := download.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := download.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// resume opens a partial file left by an earlier launch and hashes what it
+// holds, so the next segment continues after it. A partial without a
+// validator still resumes: the archive digest checked after the download
+// catches bytes from another representation, and a mismatch discards them.
+func (download *releaseDownload) resume() error {
+	status, err := os.Lstat(download.dest)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !regularRuntimeFile(download.dest) {
+		return fmt.Errorf("refusing unsafe partial download: %s", download.dest)
+	}
+	if status.Size() > download.maxBytes {
+		return download.discardPartial()
+	}
+	file, err := os.OpenFile(download.dest, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(download.hash, file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	download.file, download.written = file, written
+	validator, err := readLocalDocument(
+		download.dest+partialValidatorSuffix, maxPartialValidatorSize,
+	)
+	if err == nil {
+		download.validator = strings.TrimSpace(string(validator))
+	}
+	return nil
+}
+
+// saveValidator keeps a persistent download's validator beside its bytes, so
+// a later launch pins its Range request to the same representation.
+func (download *releaseDownload) saveValidator() error {
+	if !download.persistent || download.validator == "" {
+		return nil
+	}
+	return os.WriteFile(
+		download.dest+partialValidatorSuffix, []byte(download.validator+"\n"), 0644,
+	)
+}
+
+func (download *releaseDownload) discardPartial() error {
+	if download.file != nil {
+		_ = download.file.Close()
+		download.file = nil
+	}
+	return removePartialDownload(download.dest)
+}
+
+func removePartialDownload(path string) error {
+	_ = os.Remove(path + partialValidatorSuffix)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// partialDownloadName names the partial archive of a version directory. The
+// expected digest is part of the name, so bytes saved for one archive are
+// never resumed into another or into a re-published one.
+func partialDownloadName(archive, digest string) string {
+	return partialDownloadPrefix + digest + "-" + archive
+}
+
+// removeStalePartialDownloads removes partial archives other than keep, left
+// for a build or digest this launch no longer installs.
+func removeStalePartialDownloads(directory, keep string) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, partialDownloadPrefix) ||
+			name == keep || name == keep+partialValidatorSuffix {
+			continue
+		}
+		_ = os.Remove(filepath.Join(directory, name))
+	}
+}
+
+func (download *releaseDownload) limitError() error {
+	return fmt.Errorf(
+		"release archive exceeds the %d-byte compressed safety limit",
+		download.maxBytes,
+	)
+}
+
+// resumeValidator returns the If-Range value that ties a resumed segment to
+// the representation already on disk. Weak entity tags cannot do that.
+func resumeValidator(header http.Header) string {
+	if tag := header.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+		return tag
+	}
+	return header.Get("Last-Modified")
+}
+
+// parseContentRange parses "bytes <start>-<end>/<total>". An unknown total
+// ("*") is reported as -1.
+func parseContentRange(value string) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	span, totalText, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	startText, endText, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, startErr := strconv.ParseInt(startText, 10, 64)
+	end, endErr := strconv.ParseInt(endText, 10, 64)
+	if startErr != nil || endErr != nil || start < 0 || end < start {
+		return 0, 0, false
+	}
+	if totalText == "*" {
+		return start, -1, true
+	}
+	total, err := strconv.ParseInt(totalText, 10, 64)
+	if err != nil || total <= end {
+		return 0, 0, false
+	}
+	return start, total, true
+}
+
+func retryableDownloadStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly,
+		http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableDownloadError accepts transient transport failures: resets,
+// truncated bodies, socket errors and timeouts. Policy failures such as an
+// unsafe redirect are final.
+func retryableDownloadError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var operationErr *net.OpError
+	if errors.As(err, &operationErr) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+func retryAfterDelay(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		delay = time.Duration(seconds) * time.Second
+		if seconds > int64(downloadRetryAfterMax/time.Second) {
+			delay = downloadRetryAfterMax
+		}
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = time.Until(at)
+	}
+	if delay > downloadRetryAfterMax {
+		delay = downloadRetryAfterMax
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// downloadBackoff returns a bounded exponential delay with jitter so many
+// launches that failed together do not retry in lockstep.
+func downloadBackoff(failures int) time.Duration {
+	delay := downloadBackoffMax
+	if failures < 16 && downloadBackoffBase<<failures < downloadBackoffMax {
+		delay = downloadBackoffBase << failures
+	}
+	return delay/2 + mathrand.N(delay/2+1)
+}
+
+// fetchChecksums downloads release ver's checksums.txt and, when the trust
+// list covers that release, its detached checksums.txt.minisig from the same
+// source. The manifest's own digest is returned for provenance checks.
+func fetchChecksums(
+	url, ver string, credential *releaseCredential,
+) (map[string]string, [sha256.Size]byte, error) {
+	body, err := fetchReleaseDocument(url, credential, maxChecksumManifestSize)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	if err := requireChecksumSignature(body, ver, func() ([]byte, error) {
+		return fetchReleaseDocument(url+".minisig", credential, maxChecksumSignatureSize)
+	}); err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	checksums, err := parseChecksumManifest(body)
+	return checksums, sha256.Sum256(body), err
+}
+
+func fetchReleaseDocument(
+	url string, credential *releaseCredential, maxBytes int,
+) ([]byte, error) {
+	request, err := newReleaseRequest(url, credential)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpsOnlyClient.Do(request) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return readLimitedDocument(resp.Body, path.Base(request.URL.Path), maxBytes)
+}
+
+func readChecksumManifest(
+	manifestPath, ver string,
+) (map[string]string, [sha256.Size]byte, error) {
+	body, err := readLocalDocument(manifestPath, maxChecksumManifestSize)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	if err := requireChecksumSignature(body, ver, func() ([]byte, error) {
+		return readLocalDocument(manifestPath+".minisig", maxChecksumSignatureSize)
+	}); err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	checksums, err := parseChecksumManifest(body)
+	return checksums, sha256.Sum256(body), err
+}
+
+// requireChecksumSignature refuses release ver's manifest unless a trusted
+// key signed it, for every release the trust list reaches. A signature that
+// cannot be read is as fatal as one that does not verify.
+func requireChecksumSignature(
+	manifest []byte, ver string, signature func() ([]byte, error),
+) error {
+	if releaseSigningKeysErr != nil {
+		return releaseSigningKeysErr
+	}
+	required, err := checksumSignatureRequired(releaseSigningKeys, ver)
+	if err != nil || !required {
+		return err
+	}
+	signed, err := signature()
+	if err != nil {
+		return fmt.Errorf("checksum signature unavailable: %w", err)
+	}
+	return verifyChecksumSignature(manifest, signed, releaseSigningKeys, ver)
+}
+
+// checksumSignatureRequired reports whether release ver is at or after the
+// first release any key covers. Releases published before signing began have
+// no signature to check.
+func checksumSignatureRequired(keys []releaseSigningKey, ver string) (bool, error) {
+	parsed, err := parseReleaseVersion(ver)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if compareReleaseVersions(key.first, parsed) <= 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func readLocalDocument(path string, maxBytes int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	status, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !status.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", filepath.Base(path))
+	}
+	return readLimitedDocument(f, filepath.Base(path), maxBytes)
+}
+
+func readLimitedDocument(
+	source io.Reader, name string, maxBytes int,
+) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(source, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBytes {
+		return nil, fmt.Errorf("%s exceeds the %d-byte safety limit", name, maxBytes)
+	}
+	return body, nil
+}
+
+func parseChecksumManifest(body []byte) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 {
+			name := parts[1]
+			if strings.HasPrefix(name, "*") {
+				name = name[1:]
+			}
+			digest := strings.ToLower(parts[0])
+			if len(digest) != sha256.Size*2 {
+				return nil, fmt.Errorf("invalid SHA-256 checksum for %s", name)
+			}
+			if _, err := hex.DecodeString(digest); err != nil {
+				return nil, fmt.Errorf("invalid SHA-256 checksum for %s: %w", name, err)
+			}
+			if prior, exists := result[name]; exists && prior != digest {
+				return nil, fmt.Errorf("conflicting SHA-256 checksums for %s", name)
+			}
+			result[name] = digest
+		}
+	}
+	return result, nil
+}
+
+// expectedArchiveDigest returns the digest an archive must have. A digest
+// embedded in the wrapper wins, and the fetched manifest must list the same
+// one: any disagreement means one of the two channels is lying. The embedded
+// digests belong to the release this wrapper was built for; any other
+// selected version is checked against its own release manifest alone. Only
+// untagged builds may lack them: a wrapper installed from its module tag
+// refuses to fall back to the fetched manifest.
+func expectedArchiveDigest(
+	checksums map[string]string, ver, archive string,
+) (string, error) {
+	var pinned string
+	var embedded bool
+	if ver == version {
+		var err error
+		pinned, embedded, err = embeddedArchiveDigest(
+			embeddedReleaseChecksums, ver, archive,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+	fetched, listed := checksums[archive]
+	if !embedded {
+		if ver == version && wrapperModuleVersion() == "v"+version {
+			return "", fmt.Errorf(
+				"this wrapper was released as v%s without the embedded checksums a tagged build must carry; install a wrapper release that pins them",
+				version,
+			)
+		}
+		if !listed {
+			return "", fmt.Errorf("checksum manifest has no entry for %s", archive)
+		}
+		return fetched, nil
+	}
+	if !listed || fetched != pinned {
+		return "", fmt.Errorf(
+			"checksum manifest disagrees with the digest embedded in this wrapper for %s",
+			archive,
+		)
+	}
+	return pinned, nil
+}
+
+// embeddedArchiveDigest looks archive up in an embedded manifest. The first
+// line names the release the digests belong to; digests for any other version
+// are refused rather than ignored, because they mean the generated file and
+// the version constant drifted apart.
+func embeddedArchiveDigest(
+	manifest []byte, ver, archive string,
+) (string, bool, error) {
+	checksums, err := embeddedReleaseDigests(manifest, ver)
+	if err != nil || checksums == nil {
+		return "", false, err
+	}
+	digest, ok := checksums[archive]
+	if !ok {
+		return "", false, fmt.Errorf(
+			"embedded release checksums have no entry for %s", archive,
+		)
+	}
+	return digest, true, nil
+}
+
+// embeddedReleaseDigests parses an embedded manifest for release ver. It
+// returns nil when the manifest pins nothing.
+func embeddedReleaseDigests(manifest []byte, ver string) (map[string]string, error) {
+	var header string
+	var entries []string
+	for _, line := range strings.Split(string(manifest), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if header == "" && len(entries) == 0 {
+				header = line
+			}
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	pinnedVersion, found := strings.CutPrefix(header, "# codebase-memory-mcp ")
+	if !found || strings.TrimPrefix(pinnedVersion, "v") != ver {
+		return nil, fmt.Errorf(
+			"embedded release checksums do not belong to v%s", ver,
+		)
+	}
+	checksums, err := parseChecksumManifest(
+		[]byte(strings.Join(entries, "\n")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("embedded release checksums: %w", err)
+	}
+	return checksums, nil
+}
+
+// verifyChecksumSignature checks a minisign signature over release ver's
+// checksum manifest. Both the manifest signature and the global signature
+// binding the trusted comment are verified; the comment must name release ver,
+// and the signing key's range must include it. Only Ed25519 signatures over
+// the whole file ("minisign -S -l") are accepted: the prehashed variant needs
+// BLAKE2b, which the standard library does not provide.
+func verifyChecksumSignature(
+	manifest, signature []byte, keys []releaseSigningKey, ver string,
+) error {
+	lines := strings.Split(strings.TrimRight(string(signature), "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+	if len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], "untrusted comment:") ||
+		!strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("checksum signature is not a minisign signature")
+	}
+	packed, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(packed) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("checksum signature is malformed")
+	}
+	if string(packed[:2]) != "Ed" {
+		return fmt.Errorf(
+			"checksum signature algorithm %q is not supported", packed[:2],
+		)
+	}
+	keyID := packed[2:10]
+	manifestSignature := packed[10:]
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return fmt.Errorf("checksum signature trusted comment is malformed")
+	}
+	release, err := parseReleaseVersion(ver)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		id, publicKey, err := parseMinisignPublicKey(key.publicKey)
+		if err != nil {
+			return err
+		}
+		if id != [8]byte(keyID) {
+			continue
+		}
+		if !ed25519.Verify(publicKey, manifest, manifestSignature) {
+			return fmt.Errorf("checksum signature verification failed")
+		}
+		bound := append(
+			append([]byte(nil), manifestSignature...), trustedComment...,
+		)
+		if !ed25519.Verify(publicKey, bound, globalSignature) {
+			return fmt.Errorf("checksum signature trusted comment verification failed")
+		}
+		signed, ok := minisignCommentField(trustedComment, "version")
+		if !ok || strings.TrimPrefix(signed, "v") != release.String() {
+			return fmt.Errorf("checksum signature was not made for v%s", release)
+		}
+		if compareReleaseVersions(release, key.first) < 0 ||
+			(key.bounded && compareReleaseVersions(release, key.last) > 0) {
+			return fmt.Errorf(
+				"checksum signing key %X is not trusted for v%s", keyID, release,
+			)
+		}
+		return nil
+	}
+	return fmt.Errorf("checksum signature was made by untrusted key %X", keyID)
+}
+
+func parseMinisignPublicKey(encoded string) ([8]byte, ed25519.PublicKey, error) {
+	var id [8]byte
+	packed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(packed) != 2+8+ed25519.PublicKeySize ||
+		string(packed[:2]) != "Ed" {
+		return id, nil, fmt.Errorf("embedded checksum signing key is malformed")
+	}
+	copy(id[:], packed[2:10])
+	return id, ed25519.PublicKey(packed[10:]), nil
+}
+
+// parseReleaseSigningKeys reads the embedded trust list. Comments and blank
+// lines are skipped; every other line is a public key and the release range
+// it signs.
+func parseReleaseSigningKeys(list []byte) ([]releaseSigningKey, error) {
+	var keys []releaseSigningKey
+	for _, line := range strings.Split(string(list), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("embedded checksum signing key line is malformed: %q", line)
+		}
+		key := releaseSigningKey{publicKey: fields[0]}
+		if _, _, err := parseMinisignPublicKey(key.publicKey); err != nil {
+			return nil, err
+		}
+		var err error
+		if key.first, err = parseReleaseVersion(fields[1]); err != nil {
+			return nil, fmt.Errorf("embedded checksum signing key: %w", err)
+		}
+		if len(fields) == 3 {
+			if key.last, err = parseReleaseVersion(fields[2]); err != nil {
+				return nil, fmt.Errorf("embedded checksum signing key: %w", err)
+			}
+			key.bounded = true
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// minisignCommentField extracts a "name:value" field of a trusted comment,
+// whose fields minisign separates with tabs.
+func minisignCommentField(comment, name string) (string, bool) {
+	for _, field := range strings.Split(comment, "\t") {
+		if value, found := strings.CutPrefix(field, name+":"); found {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// writeJSONFileAtomic replaces path with value's JSON encoding so readers see
+// either the old record or the new one.
+func writeJSONFileAtomic(path string, value any) error {
+	contents, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(path), ".cbm-record-*")
+	if err != nil {
+		return err
+	}
+	_, writeErr := temporary.Write(append(contents, '\n'))
+	syncErr := temporary.Sync()
+	closeErr := temporary.Close()
+	for _, candidate := range []error{writeErr, syncErr, closeErr} {
+		if candidate != nil {
+			_ = os.Remove(temporary.Name())
+			return candidate
+		}
+	}
+	if err := os.Rename(temporary.Name(), path); err != nil {
+		_ = os.Remove(temporary.Name())
+		return err
+	}
+	return nil
+}
+
+func verifyDigest(actualDigest [sha256.Size]byte, expected string) error {
+	expected = strings.ToLower(expected)
+	if len(expected) != sha256.Size*2 {
+		return fmt.Errorf("invalid SHA-256 checksum length")
 	}
-	defer f.Close()
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return err
+	if _, err := hex.DecodeString(expected); err != nil {
+		return fmt.Errorf("invalid SHA-256 checksum: %w", err)
 	}
-	actual := hex.EncodeToString(h.Sum(nil))
+	actual := hex.EncodeToString(actualDigest[:])
 	if actual != expected {
 		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
 	}
@@ -724,62 +2217,6 @@ func copyArchiveMemberWithLimits(
 	return nil
 }
 
-func tarGzMemberNames(archivePath string) ([]string, error) {
-	return tarGzMemberNamesWithLimits(
-		archivePath, defaultArchiveResourceLimits,
-	)
-}
-
-func tarGzMemberNamesWithLimits(
-	archivePath string, limits archiveResourceLimits,
-) ([]string, error) {
-	if err := validateArchiveResourceLimits(limits); err != nil {
-		return nil, err
-	}
-	if err := requireCompressedArchiveWithinLimit(archivePath, limits); err != nil {
-		return nil, err
-	}
-	f, err := os.Open(archivePath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	gz, err := gzip.NewReader(f)
-	if err != nil {
-		return nil, err
-	}
-	defer gz.Close()
-	tr := tar.NewReader(gz)
-	var names []string
-	var declaredExpanded int64
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
-			return nil, fmt.Errorf(
-				"archive member is not a regular root file: %q", hdr.Name,
-			)
-		}
-		if len(names) >= limits.members {
-			return nil, fmt.Errorf(
-				"archive exceeds the %d-member safety limit", limits.members,
-			)
-		}
-		if err := accountDeclaredArchiveMember(
-			hdr.Name, hdr.Size, &declaredExpanded, limits,
-		); err != nil {
-			return nil, err
-		}
-		names = append(names, hdr.Name)
-	}
-	return names, nil
-}
-
 func extractTarGz(
 	archivePath, destDir string,
 	archiveNames, extractNames []string,
@@ -793,18 +2230,26 @@ func extractTarGz(
 	)
 }
 
+// extractTarGzWithLimits validates and extracts in one streaming pass: the
+// archive is decompressed once, each header is checked as it arrives, and
+// wanted members are written while the rest are discarded under the same
+// resource accounting. Anything extracted is removed if a later member or the
+// final completeness check rejects the archive.
 func extractTarGzWithLimits(
 	archivePath, destDir string,
 	archiveNames, extractNames []string,
 	limits archiveResourceLimits,
-) ([]string, error) {
-	names, err := tarGzMemberNamesWithLimits(archivePath, limits)
-	if err != nil {
+) (result []string, resultErr error) {
+	if err := validateArchiveResourceLimits(limits); err != nil {
 		return nil, err
 	}
-	if err := validateArchiveMemberNames(names, archiveNames, false); err != nil {
+	if err := requireCompressedArchiveWithinLimit(archivePath, limits); err != nil {
 		return nil, err
 	}
+	required := make(map[string]struct{}, len(archiveNames))
+	for _, name := range archiveNames {
+		required[name] = struct{}{}
+	}
 	runtimeNames := append([]string(nil), extractNames...)
 	targets := make(map[string]struct{}, len(runtimeNames))
 	for _, name := range runtimeNames {
@@ -823,7 +2268,15 @@ func extractTarGzWithLimits(
 	defer gz.Close()
 	tr := tar.NewReader(gz)
 	extracted := make(map[string]struct{}, len(runtimeNames))
-	memberCount := 0
+	defer func() {
+		if resultErr != nil {
+			for name := range extracted {
+				_ = os.Remove(filepath.Join(destDir, name))
+			}
+		}
+	}()
+	names := make([]string, 0, len(archiveNames))
+	seen := make(map[string]struct{}, len(archiveNames))
 	var declaredExpanded int64
 	var actualExpanded int64
 	for {
@@ -834,8 +2287,7 @@ func extractTarGzWithLimits(
 		if err != nil {
 			return nil, err
 		}
-		memberCount++
-		if memberCount > limits.members {
+		if len(names) >= limits.members {
 			return nil, fmt.Errorf(
 				"archive exceeds the %d-member safety limit", limits.members,
 			)
@@ -850,6 +2302,19 @@ func extractTarGzWithLimits(
 		); err != nil {
 			return nil, err
 		}
+		if _, duplicate := seen[hdr.Name]; duplicate {
+			return nil, fmt.Errorf(
+				"duplicate or case-conflicting archive entry: %q", hdr.Name,
+			)
+		}
+		if _, isRequired := required[hdr.Name]; !isRequired {
+			return nil, fmt.Errorf(
+				"archive must contain only the exact root files: %s",
+				strings.Join(archiveNames, ", "),
+			)
+		}
+		seen[hdr.Name] = struct{}{}
+		names = append(names, hdr.Name)
 		if _, wanted := targets[hdr.Name]; wanted {
 			outputPath := filepath.Join(destDir, hdr.Name)
 			out, err := os.OpenFile(
@@ -879,6 +2344,9 @@ func extractTarGzWithLimits(
 			return nil, err
 		}
 	}
+	if err := validateArchiveMemberNames(names, archiveNames, false); err != nil {
+		return nil, err
+	}
 	if len(extracted) != len(runtimeNames) {
 		return nil, fmt.Errorf("archive runtime set extraction was incomplete")
 	}
@@ -1035,11 +2503,26 @@ func validateWindowsZipMember(raw string) (string, error) {
 }
 
 func verifyCandidate(path string) error {
+	return verifyCandidateVersion(path, version)
+}
+
+// candidateVerifier verifies candidates for release ver.
+func candidateVerifier(ver string) func(string) error {
+	return func(path string) error { return verifyCandidateVersion(path, ver) }
+}
+
+func verifyCandidateVersion(path, ver string) error {
+	// A binary for the wrong platform would only fail with "exec format
+	// error", so its header is checked before anything is executed.
+	if err := verifyExecutableFormat(path, goos(), goarch()); err != nil {
+		return err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), candidateTimeout)
 	defer cancel()
+	output := &cappedBuffer{limit: maxCandidateVersionOutput}
 	cmd := exec.CommandContext(ctx, path, "--version")
 	cmd.Stdin = nil
-	cmd.Stdout = io.Discard
+	cmd.Stdout = output
 	cmd.Stderr = io.Discard
 	if err := cmd.Run(); err != nil {
 		if ctx.Err() != nil {
@@ -1047,9 +2530,116 @@ func verifyCandidate(path string) error {
 		}
 		return fmt.Errorf("downloaded binary failed to run: %w", err)
 	}
+	reported, err := parseCandidateVersion(output.Bytes())
+	if err != nil {
+		return err
+	}
+	if reported != ver {
+		return fmt.Errorf(
+			"downloaded binary reports version %s, but v%s was requested",
+			reported, ver,
+		)
+	}
+	return nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a misbehaving candidate cannot grow the wrapper's memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (buffer *cappedBuffer) Write(data []byte) (int, error) {
+	if room := buffer.limit - buffer.Len(); room > 0 {
+		buffer.Buffer.Write(data[:min(room, len(data))])
+	}
+	return len(data), nil
+}
+
+// parseCandidateVersion reads the "codebase-memory-mcp <version>" line the
+// native binary prints for --version.
+func parseCandidateVersion(output []byte) (string, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != "codebase-memory-mcp" {
+		return "", fmt.Errorf("downloaded binary printed an unrecognized version: %q", line)
+	}
+	return strings.TrimPrefix(fields[1], "v"), nil
+}
+
+// verifyExecutableFormat checks the object format and machine in the
+// executable header against the platform the wrapper runs on.
+func verifyExecutableFormat(path, platform, arch string) error {
+	format, machine := executableFormat(path)
+	if format == "" {
+		return fmt.Errorf("downloaded binary is not an ELF, Mach-O or PE executable")
+	}
+	wantFormat := "ELF"
+	switch platform {
+	case "darwin":
+		wantFormat = "Mach-O"
+	case "windows":
+		wantFormat = "PE"
+	}
+	if format != wantFormat {
+		return fmt.Errorf(
+			"downloaded binary is in %s format, but %s needs %s",
+			format, platform, wantFormat,
+		)
+	}
+	if !slices.Contains(machine, arch) {
+		return fmt.Errorf(
+			"downloaded binary is built for %s, but this machine is %s/%s",
+			strings.Join(machine, "+"), platform, arch,
+		)
+	}
 	return nil
 }
 
+// executableFormat names the object format of path and the Go architectures
+// it contains; a universal Mach-O binary may contain several.
+func executableFormat(path string) (string, []string) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		arch := map[elf.Machine]string{
+			elf.EM_X86_64:  "amd64",
+			elf.EM_AARCH64: "arm64",
+			elf.EM_386:     "386",
+			elf.EM_ARM:     "arm",
+		}[f.Machine]
+		return "ELF", []string{cmp.Or(arch, f.Machine.String())}
+	}
+	machoArch := func(cpu macho.Cpu) string {
+		return cmp.Or(map[macho.Cpu]string{
+			macho.CpuAmd64: "amd64",
+			macho.CpuArm64: "arm64",
+		}[cpu], cpu.String())
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return "Mach-O", []string{machoArch(f.Cpu)}
+	}
+	if f, err := macho.OpenFat(path); err == nil {
+		defer f.Close()
+		var arches []string
+		for _, member := range f.Arches {
+			arches = append(arches, machoArch(member.Cpu))
+		}
+		return "Mach-O", arches
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		arch := map[uint16]string{
+			pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+			pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+			pe.IMAGE_FILE_MACHINE_I386:  "386",
+		}[f.Machine]
+		return "PE", []string{cmp.Or(arch, fmt.Sprintf("machine 0x%x", f.Machine))}
+	}
+	return "", nil
+}
+
 func fileSHA256(path string) ([sha256.Size]byte, error) {
 	var digest [sha256.Size]byte
 	source, err := os.Open(path)
@@ -1148,7 +2738,144 @@ func runtimeSetReadyLocked(
 	); err != nil {
 		return false, err
 	}
-	return runtimeSetReady(directory, binaryName, verifier), nil
+	if !runtimeSetReady(directory, binaryName, verifier) {
+		return false, nil
+	}
+	if verifier != nil {
+		recordVerifiedRuntimeSet(directory, binaryName)
+	}
+	return true, nil
+}
+
+// installRuntimeSetLocked is runtimeSetReadyLocked for launches: when the
+// runtime set is not ready, install runs while the same lock is still held.
+func installRuntimeSetLocked(
+	directory, binaryName string, verifier func(string) error,
+	install func(*runtimeSetLock) error,
+) (result error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	if err := requireSafeRuntimeDirectory(directory); err != nil {
+		return err
+	}
+	lock, err := acquireRuntimeSetLock(directory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		attachRuntimeLockReleaseError(&result, releaseRuntimeSetLock(lock))
+	}()
+	if err := refreshRuntimeSetLock(lock); err != nil {
+		return err
+	}
+	if err := reconcileRuntimeBackups(
+		directory, binaryName, verifier, lock,
+	); err != nil {
+		return err
+	}
+	if runtimeSetReady(directory, binaryName, verifier) {
+		if verifier != nil {
+			recordVerifiedRuntimeSet(directory, binaryName)
+		}
+		return nil
+	}
+	return install(lock)
+}
+
+// runtimeVerifiedRecord describes the runtime binary as it was when it last
+// passed verification under the runtime-set lock.
+type runtimeVerifiedRecord struct {
+	WrapperVersion string `json:"wrapper_version"`
+	Binary         string `json:"binary"`
+	SHA256         string `json:"sha256"`
+	Size           int64  `json:"size"`
+	ModTime        int64  `json:"mtime_unix_ns"`
+	FileID         string `json:"file_id"`
+}
+
+// recordVerifiedRuntimeSet writes the verified-state record for the lock-free
+// launch path. The caller holds the runtime-set lock and has just verified the
+// binary. The record is only an optimization, so failing to write it merely
+// keeps launches on the locked path.
+func recordVerifiedRuntimeSet(directory, binaryName string) {
+	path := filepath.Join(directory, binaryName)
+	before, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	identity := platformRuntimeFileIdentity(path, before)
+	if identity == "" {
+		return
+	}
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return
+	}
+	after, err := os.Lstat(path)
+	if err != nil || !os.SameFile(before, after) ||
+		after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return
+	}
+	_ = writeJSONFileAtomic(
+		filepath.Join(directory, runtimeVerifiedRecordName),
+		runtimeVerifiedRecord{
+			WrapperVersion: version,
+			Binary:         binaryName,
+			SHA256:         hex.EncodeToString(digest[:]),
+			Size:           after.Size(),
+			ModTime:        after.ModTime().UnixNano(),
+			FileID:         identity,
+		},
+	)
+}
+
+// runtimeSetVerifiedUnlocked is the launch fast path. It takes no lock and
+// runs nothing: the record written under the lock is trusted only while the
+// binary's size, modification time and file identity are exactly as recorded
+// and no publication transaction is pending. Anything else falls back to the
+// locked path, which reconciles, verifies and rewrites the record.
+func runtimeSetVerifiedUnlocked(directory, binaryName string) bool {
+	record, ok := readRuntimeVerifiedRecord(directory)
+	if !ok || record.WrapperVersion != version || record.Binary != binaryName ||
+		record.FileID == "" {
+		return false
+	}
+	if requireSafeRuntimeDirectory(directory) != nil {
+		return false
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if runtimeBackupDirectoryName(entry.Name()) {
+			return false
+		}
+	}
+	path := filepath.Join(directory, binaryName)
+	status, err := os.Lstat(path)
+	if err != nil || !status.Mode().IsRegular() ||
+		!platformRuntimeSetFileLinkCountOne(path, status) {
+		return false
+	}
+	return status.Size() == record.Size &&
+		status.ModTime().UnixNano() == record.ModTime &&
+		platformRuntimeFileIdentity(path, status) == record.FileID
+}
+
+func readRuntimeVerifiedRecord(directory string) (runtimeVerifiedRecord, bool) {
+	var record runtimeVerifiedRecord
+	recordFile, err := os.Open(filepath.Join(directory, runtimeVerifiedRecordName))
+	if err != nil {
+		return record, false
+	}
+	contents, err := io.ReadAll(io.LimitReader(recordFile, maxRuntimeVerifiedRecord+1))
+	recordFile.Close()
+	if err != nil || len(contents) > maxRuntimeVerifiedRecord {
+		return record, false
+	}
+	return record, json.Unmarshal(contents, &record) == nil
 }
 
 func copyRuntimeStage(
@@ -1511,7 +3238,8 @@ func reconcileRuntimeBackups(
 	}
 	if len(backups) != 1 {
 		return fmt.Errorf(
-			"multiple package-cache backup transactions require manual recovery",
+			"multiple package-cache backup transactions require manual recovery; run %q",
+			"codebase-memory-mcp wrapper repair",
 		)
 	}
 	backup := backups[0]
@@ -1524,7 +3252,8 @@ func reconcileRuntimeBackups(
 			if target, exists := targets[name]; exists &&
 				!pathMatchesSHA256(target, member.digest) {
 				return fmt.Errorf(
-					"package-cache backup conflicts with current target: %s", name,
+					"package-cache backup conflicts with current target: %s; run %q",
+					name, "codebase-memory-mcp wrapper repair",
 				)
 			}
 		}
@@ -1675,7 +3404,14 @@ func captureRuntimeSetLockObject(
 	return descriptorStatus, nil
 }
 
-func writeRuntimeSetLockRecord(owner *os.File, token string) error {
+func writeRuntimeSetLockRecord(
+	owner *os.File, token string, kernel *runtimeSetKernelLock,
+	progress runtimeSetLockProgress,
+) error {
+	kernelIdentity := ""
+	if kernel != nil {
+		kernelIdentity = kernel.identity
+	}
 	if err := owner.Truncate(0); err != nil {
 		return err
 	}
@@ -1683,9 +3419,12 @@ func writeRuntimeSetLockRecord(owner *os.File, token string) error {
 		return err
 	}
 	if err := json.NewEncoder(owner).Encode(runtimeSetLockOwnerRecord{
-		PID:          os.Getpid(),
-		Token:        token,
-		LeaseExpires: time.Now().Add(runtimeSetLockLease).UnixMilli(),
+		PID:                     os.Getpid(),
+		Token:                   token,
+		LeaseExpires:            time.Now().Add(runtimeSetLockLease).UnixMilli(),
+		KernelLock:              kernelIdentity,
+		runtimeSetOwnerIdentity: localRuntimeSetOwnerIdentity(),
+		runtimeSetLockProgress:  progress,
 	}); err != nil {
 		return err
 	}
@@ -1721,9 +3460,14 @@ func runtimeSetOwnerReclaimable(
 ) bool {
 	if ownerOK {
 		// Lease expiry and age are not evidence that a syntactically valid
-		// owner is dead. A paused live publisher can resume between lock-owner
-		// assertions, so reclaim only when the platform proves its PID is gone.
-		return !runtimeSetProcessAlive(owner.PID)
+		// local owner is dead. A paused live publisher can resume between
+		// lock-owner assertions, so reclaim only when the platform proves its
+		// process is gone. An owner on another host, boot or PID namespace
+		// cannot be probed, and only its lease can speak for it.
+		if !runtimeSetOwnerLocal(owner) {
+			return runtimeSetForeignOwnerReclaimable(status, owner)
+		}
+		return !runtimeSetOwnerAlive(owner)
 	}
 	return time.Since(status.ModTime()) >= runtimeSetOwnerlessStale
 }
@@ -1738,6 +3482,16 @@ func restoreDisplacedRuntimeSetLock(reclaimed, lockPath string, status os.FileIn
 }
 
 func runtimeSetTryReclaimLock(lockPath, contenderToken string) bool {
+	return runtimeSetReclaimLockIf(lockPath, contenderToken, runtimeSetOwnerReclaimable)
+}
+
+// runtimeSetReclaimLockIf moves the lock aside when reclaimable accepts its
+// owner, both as first observed and again once the lock object has been
+// pinned and renamed, so an owner that replaced it in between is put back.
+func runtimeSetReclaimLockIf(
+	lockPath, contenderToken string,
+	reclaimable func(os.FileInfo, runtimeSetLockOwnerRecord, bool) bool,
+) bool {
 	observedStatus, err := os.Lstat(lockPath)
 	if os.IsNotExist(err) {
 		return true
@@ -1747,7 +3501,7 @@ func runtimeSetTryReclaimLock(lockPath, contenderToken string) bool {
 		return false
 	}
 	owner, ownerOK := runtimeSetLockOwner(lockPath)
-	if !runtimeSetOwnerReclaimable(observedStatus, owner, ownerOK) {
+	if !reclaimable(observedStatus, owner, ownerOK) {
 		return false
 	}
 	// Pin identity only after the observed owner is reclaimable. On Windows,
@@ -1766,7 +3520,7 @@ func runtimeSetTryReclaimLock(lockPath, contenderToken string) bool {
 		return false
 	}
 	movedOwner, movedOwnerOK := runtimeSetLockOwner(reclaimed)
-	if !runtimeSetOwnerReclaimable(movedStatus, movedOwner, movedOwnerOK) {
+	if !reclaimable(movedStatus, movedOwner, movedOwnerOK) {
 		restoreDisplacedRuntimeSetLock(reclaimed, lockPath, movedStatus)
 		return false
 	}
@@ -1796,23 +3550,152 @@ func assertRuntimeSetLockOwner(lock *runtimeSetLock) error {
 }
 
 func refreshRuntimeSetLock(lock *runtimeSetLock) error {
+	if lock == nil {
+		return assertRuntimeSetLockOwner(lock)
+	}
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	return writeRuntimeSetLockLease(lock)
+}
+
+// writeRuntimeSetLockLease rewrites the owner record with a new lease. The
+// caller holds lock.mu.
+func writeRuntimeSetLockLease(lock *runtimeSetLock) error {
 	if err := assertRuntimeSetLockOwner(lock); err != nil {
 		return err
 	}
-	if err := writeRuntimeSetLockRecord(lock.file, lock.token); err != nil {
+	if err := writeRuntimeSetLockRecord(
+		lock.file, lock.token, lock.kernel, lock.progress,
+	); err != nil {
 		return err
 	}
 	return assertRuntimeSetLockOwner(lock)
 }
 
+// reportRuntimeSetLockProgress publishes the owner's install progress in the
+// lock record. Byte counts are written at most every
+// runtimeSetLockProgressInterval; a new phase is written at once.
+func reportRuntimeSetLockProgress(
+	lock *runtimeSetLock, phase string, done, total int64,
+) error {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	now := time.Now()
+	if phase == lock.progress.Phase &&
+		now.Sub(lock.reported) < runtimeSetLockProgressInterval {
+		return nil
+	}
+	lock.progress = runtimeSetLockProgress{
+		Phase: phase, Done: done, Total: total, Advanced: now.UnixMilli(),
+	}
+	lock.reported = now
+	return writeRuntimeSetLockLease(lock)
+}
+
+// startRuntimeSetLockHeartbeat renews the lease every runtimeSetLockHeartbeat
+// until the lock is released. Progress reports renew it too, but an owner
+// can spend minutes in retries, backoff or a slow request without reporting
+// any, and an owner on another host is judged by its lease alone. A renewal
+// that finds the lock taken stops the heartbeat; the owner's next ownership
+// check then reports it.
+func startRuntimeSetLockHeartbeat(lock *runtimeSetLock) {
+	stop := make(chan struct{})
+	lock.heartbeat = stop
+	lock.beating.Add(1)
+	go func() {
+		defer lock.beating.Done()
+		ticker := time.NewTicker(runtimeSetLockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if refreshRuntimeSetLock(lock) != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// stopRuntimeSetLockHeartbeat stops the renewals and waits for one in flight.
+func stopRuntimeSetLockHeartbeat(lock *runtimeSetLock) {
+	if lock == nil || lock.heartbeat == nil {
+		return
+	}
+	close(lock.heartbeat)
+	lock.heartbeat = nil
+	lock.beating.Wait()
+}
+
 func acquireRuntimeSetLock(destinationDirectory string) (*runtimeSetLock, error) {
+	return acquireRuntimeSetLockWithin(destinationDirectory, runtimeSetLockWait)
+}
+
+// tryAcquireRuntimeSetLock takes the lock only if no live owner holds it. It
+// reports a held lock as not acquired rather than as an error.
+func tryAcquireRuntimeSetLock(destinationDirectory string) (*runtimeSetLock, bool, error) {
+	lock, err := acquireRuntimeSetLockWithin(destinationDirectory, 0)
+	if errors.Is(err, errRuntimeSetLockTimeout) {
+		return nil, false, nil
+	}
+	return lock, err == nil, err
+}
+
+var errRuntimeSetLockTimeout = errors.New(
+	"timed out waiting for package-cache runtime-set publication lock",
+)
+
+// acquireRuntimeSetLockWithin takes the kernel lock where the filesystem
+// supports one, which queues contenders without polling, and then claims the
+// link-based lock, which wrappers that predate the kernel lock still honour.
+func acquireRuntimeSetLockWithin(
+	destinationDirectory string, wait time.Duration,
+) (_ *runtimeSetLock, result error) {
 	token, err := runtimeSetLockToken()
 	if err != nil {
 		return nil, err
 	}
 	lockPath := filepath.Join(destinationDirectory, runtimeSetLockName)
 	claimPath := lockPath + ".claim-" + token
-	deadline := time.Now().Add(runtimeSetLockWait)
+	deadline := time.Now().Add(wait)
+	var lastAdvanced int64
+	// A caller that never waits stays quiet.
+	mode := progressMode(os.Stderr)
+	if wait == 0 {
+		mode = "off"
+	}
+	waitProgress := newProgressReporter(mode, os.Stderr)
+	defer waitProgress.finishWait()
+	// keepWaiting reports whether to go on waiting for the current owner.
+	// runtimeSetLockWait bounds a stalled owner, not a busy one: every
+	// advance the owner publishes restarts the wait.
+	keepWaiting := func() bool {
+		if holder, ok := runtimeSetLockOwner(lockPath); ok &&
+			holder.Advanced != 0 && holder.Advanced != lastAdvanced {
+			lastAdvanced = holder.Advanced
+			deadline = time.Now().Add(wait)
+			waitProgress.wait(holder)
+		}
+		if runtimeSetLockWaitObserver != nil {
+			runtimeSetLockWaitObserver()
+		}
+		return time.Now().Before(deadline)
+	}
+	kernel, err := runtimeSetKernelLockAcquire(destinationDirectory, keepWaiting)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if result != nil {
+			releaseRuntimeSetKernelLock(kernel)
+		}
+	}()
+	reclaimable := runtimeSetOwnerReclaimable
+	if kernel != nil {
+		reclaimable = kernel.reclaimable
+	}
 	for {
 		owner, claimErr := os.OpenFile(
 			claimPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600,
@@ -1820,7 +3703,9 @@ func acquireRuntimeSetLock(destinationDirectory string) (*runtimeSetLock, error)
 		if claimErr != nil {
 			return nil, claimErr
 		}
-		if err := writeRuntimeSetLockRecord(owner, token); err != nil {
+		if err := writeRuntimeSetLockRecord(
+			owner, token, kernel, runtimeSetLockProgress{},
+		); err != nil {
 			_ = owner.Close()
 			_ = os.Remove(claimPath)
 			return nil, err
@@ -1868,28 +3753,37 @@ func acquireRuntimeSetLock(destinationDirectory string) (*runtimeSetLock, error)
 					"package-cache runtime-set publication lock ownership changed",
 				)
 			}
-			return &runtimeSetLock{path: lockPath, token: token, file: owner}, nil
+			lock := &runtimeSetLock{
+				path: lockPath, token: token, file: owner, kernel: kernel,
+			}
+			startRuntimeSetLockHeartbeat(lock)
+			return lock, nil
 		}
 		_ = os.Remove(claimPath)
 		if !os.IsExist(linkErr) {
 			return nil, linkErr
 		}
-		if runtimeSetTryReclaimLock(lockPath, token) {
+		if runtimeSetReclaimLockIf(lockPath, token, reclaimable) {
 			continue
 		}
-		if runtimeSetLockWaitObserver != nil {
-			runtimeSetLockWaitObserver()
-		}
-		if !time.Now().Before(deadline) {
-			return nil, fmt.Errorf(
-				"timed out waiting for package-cache runtime-set publication lock",
-			)
+		// Only an owner without the kernel lock, an older wrapper or one on
+		// a filesystem without kernel locking, is waited for by polling.
+		if !keepWaiting() {
+			return nil, errRuntimeSetLockTimeout
 		}
 		time.Sleep(runtimeSetLockPoll)
 	}
 }
 
+// releaseRuntimeSetLock stops the heartbeat, then gives up the link-based
+// lock and then the kernel lock, so a contender that gets the kernel lock
+// never finds this owner's link lock still in place and reclaims it from
+// under the release.
 func releaseRuntimeSetLock(lock *runtimeSetLock) error {
+	stopRuntimeSetLockHeartbeat(lock)
+	if lock != nil {
+		defer releaseRuntimeSetKernelLock(lock.kernel)
+	}
 	if err := assertRuntimeSetLockOwner(lock); err != nil {
 		if lock != nil && lock.file != nil {
 			_ = lock.file.Close()
@@ -1909,6 +3803,9 @@ func releaseRuntimeSetLock(lock *runtimeSetLock) error {
 			"package-cache runtime-set publication lock ownership changed",
 		)
 	}
+	if lock.retired {
+		unlinkRetiredRuntimeSetKernelLock(lock)
+	}
 	if err := lock.file.Close(); err != nil {
 		lock.file = nil
 		return err
@@ -1947,8 +3844,7 @@ func publishRuntimeSetWithRecoveryAndRenamer(
 	verifier func(string) error,
 	renameFile func(string, string) error,
 ) (result error) {
-	sourceNames, ok := runtimeSetNames(sourceDirectory, binaryName)
-	if !ok {
+	if _, ok := runtimeSetNames(sourceDirectory, binaryName); !ok {
 		return fmt.Errorf("source release does not contain a complete runtime set")
 	}
 	if verifier != nil {
@@ -1969,6 +3865,24 @@ func publishRuntimeSetWithRecoveryAndRenamer(
 	defer func() {
 		attachRuntimeLockReleaseError(&result, releaseRuntimeSetLock(lock))
 	}()
+	return publishRuntimeSetLocked(
+		sourceDirectory, destinationDirectory, binaryName, verifier,
+		renameFile, lock,
+	)
+}
+
+// publishRuntimeSetLocked publishes a verified source runtime set while the
+// caller holds the destination's runtime-set lock.
+func publishRuntimeSetLocked(
+	sourceDirectory, destinationDirectory, binaryName string,
+	verifier func(string) error,
+	renameFile func(string, string) error,
+	lock *runtimeSetLock,
+) error {
+	sourceNames, ok := runtimeSetNames(sourceDirectory, binaryName)
+	if !ok {
+		return fmt.Errorf("source release does not contain a complete runtime set")
+	}
 	if err := refreshRuntimeSetLock(lock); err != nil {
 		return err
 	}
@@ -1983,6 +3897,9 @@ func publishRuntimeSetWithRecoveryAndRenamer(
 		return nil
 	}
 
+	// The binary is about to change; a launch must not trust the old record
+	// even for the instant before the replacement's identity differs.
+	_ = os.Remove(filepath.Join(destinationDirectory, runtimeVerifiedRecordName))
 	staged := make(map[string]string, len(sourceNames))
 	backupDirectory := ""
 	defer func() {
@@ -2096,6 +4013,9 @@ func publishRuntimeSetWithRecoveryAndRenamer(
 		if err := cleanupRuntimeBackup(backup, lock); err != nil {
 			return err
 		}
+		if verifier != nil {
+			recordVerifiedRuntimeSet(destinationDirectory, binaryName)
+		}
 		return nil
 	}
 	preserveWinner := runtimeSetReady(
@@ -2289,11 +4209,11 @@ func execBinaryWithRuntimeLockAndRunner(
 	return runner(snapshotExecutable, args)
 }
 
-func execBinaryWithRuntimeLock(executable string, args []string) error {
+func execBinaryWithRuntimeLock(executable, ver string, args []string) error {
 	return execBinaryWithRuntimeLockAndRunner(
 		executable,
 		args,
-		verifyCandidate,
+		candidateVerifier(ver),
 		runMutationBinary,
 	)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// runtimeSetForeignClockSkew is how far another host's clock may run behind
+// this one before its unexpired lease is taken as expired.
+const runtimeSetForeignClockSkew = time.Minute
+
+// runtimeSetOwnerIdentity places a lock owner's PID: the host, its boot, and
+// the PID namespace the PID belongs to, plus the process's start time so a
+// recycled PID is not mistaken for the owner. Records written by older
+// wrappers carry none of it and are judged by PID alone, as they always were.
+type runtimeSetOwnerIdentity struct {
+	Host         string `json:"host,omitempty"`
+	BootID       string `json:"boot_id,omitempty"`
+	PIDNamespace string `json:"pid_namespace,omitempty"`
+	StartTime    string `json:"start_time,omitempty"`
+}
+
+// localRuntimeSetOwnerIdentity describes this process. Host, boot and
+// namespace are fixed for its lifetime; only the start time is per process.
+func localRuntimeSetOwnerIdentity() runtimeSetOwnerIdentity {
+	identity := runtimeSetLocalHost()
+	identity.StartTime, _ = runtimeSetProcessStartTime(os.Getpid())
+	return identity
+}
+
+func detectRuntimeSetLocalHost() runtimeSetOwnerIdentity {
+	host, _ := os.Hostname()
+	return runtimeSetOwnerIdentity{
+		Host:         host,
+		BootID:       platformBootID(),
+		PIDNamespace: platformPIDNamespace(),
+	}
+}
+
+// runtimeSetOwnerLocal reports whether the owner's PID can be probed here:
+// the record comes from this host, this boot and this PID namespace. A lock
+// on a volume shared with another container or machine, or left from before
+// a reboot, fails this and is judged by its lease instead.
+func runtimeSetOwnerLocal(owner runtimeSetLockOwnerRecord) bool {
+	if owner.Host == "" {
+		return true
+	}
+	local := runtimeSetLocalHost()
+	return owner.Host == local.Host && owner.BootID == local.BootID &&
+		owner.PIDNamespace == local.PIDNamespace
+}
+
+// runtimeSetOwnerAlive reports whether a local owner may still be running.
+// Only a PID the platform proves gone, or one now held by a process that
+// started at another time, is dead; a start time that cannot be read is not
+// evidence either way.
+func runtimeSetOwnerAlive(owner runtimeSetLockOwnerRecord) bool {
+	if !runtimeSetProcessAlive(owner.PID) {
+		return false
+	}
+	if owner.StartTime == "" {
+		return true
+	}
+	started, ok := runtimeSetProcessStartTime(owner.PID)
+	return !ok || started == owner.StartTime
+}
+
+// runtimeSetForeignOwnerReclaimable applies the lease to an owner whose PID
+// means nothing here. A live owner's heartbeat renews its lease every
+// runtimeSetLockHeartbeat for as long as it holds the lock, so only one that
+// stopped running loses the lock.
+// A foreign record without a lease is judged by the lock file's age.
+func runtimeSetForeignOwnerReclaimable(status os.FileInfo, owner runtimeSetLockOwnerRecord) bool {
+	if owner.LeaseExpires > 0 {
+		expired := time.UnixMilli(owner.LeaseExpires).Add(runtimeSetForeignClockSkew)
+		return time.Now().After(expired)
+	}
+	return time.Since(status.ModTime()) >= runtimeSetLegacyStale
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	systemPolicyName    = "wrapper-policy.json"
+	maxSystemPolicySize = 64 * 1024
+)
+
+// systemPolicy is the machine-wide wrapper policy an administrator installs
+// for every user:
+//
+//	{"offline": true}
+//
+// Unlike the environment and a repository's wrapper.json, nothing a user or a
+// checkout sets can relax it.
+type systemPolicy struct {
+	Offline bool `json:"offline"`
+}
+
+// systemPolicyPath is /etc/codebase-memory-mcp/wrapper-policy.json, or the
+// same name under %ProgramData% on Windows.
+func systemPolicyPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(
+			cmp.Or(os.Getenv("ProgramData"), `C:\ProgramData`),
+			"codebase-memory-mcp", systemPolicyName,
+		)
+	}
+	return filepath.Join("/etc", "codebase-memory-mcp", systemPolicyName)
+}
+
+// loadSystemPolicy reads the policy file. A missing file is no policy; a file
+// that exists but cannot be read or parsed is an error, because a broken
+// policy must not silently allow what it was installed to forbid.
+func loadSystemPolicy() (systemPolicy, string, error) {
+	var policy systemPolicy
+	path := systemPolicyLocation()
+	body, err := readLocalDocument(path, maxSystemPolicySize)
+	if os.IsNotExist(err) {
+		return policy, "", nil
+	}
+	if err != nil {
+		return policy, path, fmt.Errorf("wrapper policy %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return policy, path, fmt.Errorf("wrapper policy %s: %w", path, err)
+	}
+	if decoder.More() {
+		return policy, path, fmt.Errorf("wrapper policy %s: trailing data after the policy", path)
+	}
+	return policy, path, nil
+}
+
+// offlinePolicy reports whether the wrapper may use the network, and what
+// forbade it: the system policy file or CBM_GO_OFFLINE. Offline, a launch
+// runs only a runtime set that is already cached, reused or provided as a
+// local archive through CBM_GO_ARCHIVE.
+func offlinePolicy() (bool, string, error) {
+	policy, path, err := loadSystemPolicy()
+	if err != nil {
+		return true, path, err
+	}
+	if policy.Offline {
+		return true, path, nil
+	}
+	switch setting := strings.ToLower(wrapperSetting("CBM_GO_OFFLINE")); setting {
+	case "", "0", "false", "off":
+		return false, "", nil
+	case "1", "true", "on":
+		return true, "CBM_GO_OFFLINE", nil
+	default:
+		return true, "CBM_GO_OFFLINE", fmt.Errorf(
+			"CBM_GO_OFFLINE must be \"1\", \"true\", \"on\", \"0\", \"false\" or \"off\"",
+		)
+	}
+}
+
+// requireNetworkAllowed is checked before any release request is made.
+func requireNetworkAllowed() error {
+	offline, source, err := offlinePolicy()
+	if err != nil {
+		return err
+	}
+	if offline {
+		return fmt.Errorf("network access is disabled by %s", source)
+	}
+	return nil
+}
+
+// offlineInstallError explains how to provision release ver without the
+// network: which archive to fetch elsewhere, the digest it must have when
+// this wrapper pins one, and where to point CBM_GO_ARCHIVE.
+func offlineInstallError(ver, archive, source string) error {
+	digest := "with its checksums.txt line"
+	if ver == version {
+		if pinned, embedded, err := embeddedArchiveDigest(
+			embeddedReleaseChecksums, ver, archive,
+		); err == nil && embedded {
+			digest = "SHA-256 " + pinned
+		}
+	}
+	location := ""
+	if release, err := releaseSourceFromSettings(); err == nil {
+		location = " from " + release.assetURL(ver, archive)
+	}
+	return fmt.Errorf(
+		"v%s is not installed and %s forbids downloading it; fetch %s (%s)%s on a connected machine, place it beside the release's checksums.txt, and set CBM_GO_ARCHIVE to that file or directory",
+		ver, source, archive, digest, location,
+	)
+}
+
+// wrapperCheck reports, without installing, running or writing anything,
+// whether launching the selected version would need the network. It returns
+// true when it would.
+func wrapperCheck(stdout io.Writer) (bool, error) {
+	offline, policySource, err := offlinePolicy()
+	if err != nil {
+		return true, err
+	}
+	if offline {
+		fmt.Fprintf(stdout, "policy: offline (%s)\n", policySource)
+	} else {
+		fmt.Fprintln(stdout, "policy: downloads allowed")
+	}
+	ver, err := selectedVersion()
+	if err != nil {
+		return true, err
+	}
+	variants, err := linuxArchiveVariants()
+	if err != nil {
+		return true, err
+	}
+	binary := binPath(ver)
+	directory, binaryName := filepath.Dir(binary), filepath.Base(binary)
+	fmt.Fprintf(stdout, "version: v%s for %s\n", ver, filepath.Base(filepath.Dir(directory)))
+	if enabled, _ := reuseEnabled(); enabled {
+		if record := readRuntimeReuseRecord(directory); record.Reused != nil {
+			if stamp, ok := stampInstalledBinary(record.Reused.Path); ok && stamp == *record.Reused {
+				fmt.Fprintf(stdout, "runtime: reuses %s; no network needed\n", stamp.Path)
+				return false, nil
+			}
+		}
+	}
+	switch {
+	case runtimeSetVerifiedUnlocked(directory, binaryName):
+		fmt.Fprintf(stdout, "runtime: verified in %s; no network needed\n", directory)
+		return false, nil
+	case regularRuntimeFile(binary):
+		fmt.Fprintf(stdout, "runtime: cached in %s and re-verified locally at launch; no network needed\n", directory)
+		return false, nil
+	case regularRuntimeFile(filepath.Join(cacheDir(), ver, binaryName)):
+		fmt.Fprintf(stdout, "runtime: cached in the older layout under %s and moved locally at launch; no network needed\n", cacheDir())
+		return false, nil
+	}
+	if local := wrapperSetting("CBM_GO_ARCHIVE"); local != "" {
+		fmt.Fprintf(stdout, "runtime: not installed; a launch installs it from %s; no network needed\n", local)
+		return false, nil
+	}
+	archive := releaseArchiveName(goos(), goarch(), variants[0])
+	if offline {
+		fmt.Fprintf(stdout, "runtime: not installed; %v\n", offlineInstallError(ver, archive, policySource))
+		return true, nil
+	}
+	release, err := releaseSourceFromSettings()
+	if err != nil {
+		return true, err
+	}
+	fmt.Fprintf(stdout, "runtime: not installed; a launch downloads %s\n", release.assetURL(ver, archive))
+	return true, nil
+}
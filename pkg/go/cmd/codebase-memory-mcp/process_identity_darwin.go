@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// darwinKinfoProcSize is sizeof(struct kinfo_proc) on 64-bit macOS. Its first
+// member, extern_proc, begins with p_starttime as a 64-bit timeval.
+const darwinKinfoProcSize = 648
+
+// platformProcessStartTime reads p_starttime through the kern.proc.pid
+// sysctl.
+func platformProcessStartTime(pid int) (string, bool) {
+	mib := [4]int32{1 /* CTL_KERN */, 14 /* KERN_PROC */, 1 /* KERN_PROC_PID */, int32(pid)}
+	var info [darwinKinfoProcSize]byte
+	size := uintptr(len(info))
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS___SYSCTL,
+		uintptr(unsafe.Pointer(&mib[0])), uintptr(len(mib)),
+		uintptr(unsafe.Pointer(&info[0])), uintptr(unsafe.Pointer(&size)),
+		0, 0,
+	)
+	// A PID with no process returns success and no data.
+	if errno != 0 || size < 16 {
+		return "", false
+	}
+	seconds := int64(binary.LittleEndian.Uint64(info[0:8]))
+	microseconds := int64(binary.LittleEndian.Uint32(info[8:12]))
+	return strconv.FormatInt(seconds*1000000+microseconds, 10), true
+}
+
+func platformBootID() string {
+	id, err := syscall.Sysctl("kern.bootsessionuuid")
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// platformPIDNamespace is empty: macOS has a single PID space per boot.
+func platformPIDNamespace() string {
+	return ""
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// platformProcessStartTime reads field 22 of /proc/PID/stat: the clock tick
+// since boot at which the process started, unique together with the boot ID.
+func platformProcessStartTime(pid int) (string, bool) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", false
+	}
+	// The command name in field 2 may contain spaces and parentheses, so
+	// fields are counted from its closing parenthesis.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return "", false
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return "", false
+	}
+	return fields[19], true
+}
+
+func platformBootID() string {
+	id, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(id))
+}
+
+// platformPIDNamespace is the inode of this process's PID namespace, such as
+// "pid:[4026531836]". Containers on one host differ here even when they share
+// its hostname and boot ID.
+func platformPIDNamespace() string {
+	namespace, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return ""
+	}
+	return namespace
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+// Unsupported wrapper platforms record no start time, so a live PID is never
+// taken for a recycled one, and identify a lock's host by hostname alone.
+func platformProcessStartTime(int) (string, bool) {
+	return "", false
+}
+
+func platformBootID() string {
+	return ""
+}
+
+func platformPIDNamespace() string {
+	return ""
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+const windowsProcessQueryLimitedInformation = 0x1000
+
+// platformProcessStartTime reads the process creation time, which stays
+// unique to it even after Windows hands its PID to another process.
+func platformProcessStartTime(pid int) (string, bool) {
+	if pid <= 0 || uint64(pid) > uint64(^uint32(0)) {
+		return "", false
+	}
+	handle, err := syscall.OpenProcess(windowsProcessQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return "", false
+	}
+	defer syscall.CloseHandle(handle)
+	var created, exited, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &created, &exited, &kernel, &user); err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(created.Nanoseconds(), 10), true
+}
+
+// platformBootID is empty on Windows. A process creation time already
+// differs across reboots, which is what the boot ID guards elsewhere.
+func platformBootID() string {
+	return ""
+}
+
+func platformPIDNamespace() string {
+	return ""
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	progressDrawInterval   = 100 * time.Millisecond
+	progressNDJSONInterval = 500 * time.Millisecond
+)
+
+// progressReporter reports install progress on stderr. stdout belongs to the
+// MCP client and is never written. CBM_GO_PROGRESS selects the form:
+//
+//	auto    redrawn status line when stderr is a terminal, otherwise off
+//	tty     redrawn status line with bytes, rate and ETA
+//	ndjson  one JSON object per line, for IDE integrations and CI logs
+//	off     only the one-line install notice
+type progressReporter struct {
+	mode       string
+	out        io.Writer
+	started    time.Time
+	phase      string
+	phaseStart time.Time
+	emitted    time.Time
+	drawn      bool
+}
+
+// progressEvent is one NDJSON progress line.
+type progressEvent struct {
+	Event     string  `json:"event"`
+	Phase     string  `json:"phase,omitempty"`
+	Version   string  `json:"version,omitempty"`
+	Platform  string  `json:"platform,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Done      int64   `json:"done_bytes,omitempty"`
+	Total     int64   `json:"total_bytes,omitempty"`
+	Rate      float64 `json:"rate_bytes_per_s,omitempty"`
+	ETA       float64 `json:"eta_s,omitempty"`
+	OwnerPID  int     `json:"owner_pid,omitempty"`
+	ElapsedMS int64   `json:"elapsed_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Message   string  `json:"message,omitempty"`
+	Timestamp int64   `json:"timestamp_ms"`
+}
+
+// progressModeWarning makes an unknown CBM_GO_PROGRESS warn once per process,
+// however many installs and waits consult it.
+var progressModeWarning sync.Once
+
+// progressMode resolves CBM_GO_PROGRESS. Progress is cosmetic, so an unknown
+// value warns on warnings and falls back to auto rather than failing a launch.
+func progressMode(warnings io.Writer) string {
+	switch setting := wrapperSetting("CBM_GO_PROGRESS"); setting {
+	case "", "auto":
+	case "tty", "ndjson", "off":
+		return setting
+	default:
+		progressModeWarning.Do(func() {
+			fmt.Fprintf(warnings,
+				"codebase-memory-mcp: warning: CBM_GO_PROGRESS=%q is not \"auto\", \"tty\", \"ndjson\" or \"off\"; using auto\n",
+				setting,
+			)
+		})
+	}
+	if stderrIsTerminal() {
+		return "tty"
+	}
+	return "off"
+}
+
+func newProgressReporter(mode string, out io.Writer) *progressReporter {
+	return &progressReporter{mode: mode, out: out, started: time.Now()}
+}
+
+// begin announces an install. Outside NDJSON mode this is the one line the
+// wrapper has always printed, so it is shown even with progress off.
+func (reporter *progressReporter) begin(ver, platform, arch, source string) {
+	if reporter.mode == "ndjson" {
+		reporter.emit(progressEvent{
+			Event: "start", Version: ver,
+			Platform: platform + "/" + arch, Source: source,
+		})
+		return
+	}
+	if source == "" {
+		fmt.Fprintf(reporter.out, "codebase-memory-mcp: downloading v%s for %s/%s...\n", ver, platform, arch)
+		return
+	}
+	fmt.Fprintf(reporter.out, "codebase-memory-mcp: installing v%s for %s/%s from %s...\n", ver, platform, arch, source)
+}
+
+// update records progress in phase; done and total are bytes when known.
+func (reporter *progressReporter) update(phase string, done, total int64) {
+	now := time.Now()
+	changed := phase != reporter.phase
+	if changed {
+		reporter.phase = phase
+		reporter.phaseStart = now
+	}
+	switch reporter.mode {
+	case "ndjson":
+		if changed {
+			reporter.emit(progressEvent{Event: "phase", Phase: phase})
+		}
+		if done > 0 && (changed || now.Sub(reporter.emitted) >= progressNDJSONInterval ||
+			(total > 0 && done >= total)) {
+			rate, eta := reporter.rate(now, done, total)
+			reporter.emit(progressEvent{
+				Event: "progress", Phase: phase, Done: done, Total: total,
+				Rate: rate, ETA: eta,
+			})
+		}
+	case "tty":
+		if !changed && now.Sub(reporter.emitted) < progressDrawInterval &&
+			(total == 0 || done < total) {
+			return
+		}
+		reporter.draw(describeProgress(phase, done, total) +
+			reporter.rateText(now, done, total))
+	}
+}
+
+// wait shows a launch waiting on another process's install.
+func (reporter *progressReporter) wait(owner runtimeSetLockOwnerRecord) {
+	now := time.Now()
+	switch reporter.mode {
+	case "ndjson":
+		if now.Sub(reporter.emitted) < progressNDJSONInterval {
+			return
+		}
+		reporter.emit(progressEvent{
+			Event: "wait", Phase: owner.Phase, OwnerPID: owner.PID,
+			Done: owner.Done, Total: owner.Total,
+		})
+	case "tty":
+		if now.Sub(reporter.emitted) < time.Second {
+			return
+		}
+		reporter.draw(fmt.Sprintf(
+			"waiting for process %d: %s", owner.PID,
+			describeProgress(owner.Phase, owner.Done, owner.Total),
+		))
+	}
+}
+
+// note reports something the user should know about an install that is
+// otherwise going ahead.
+func (reporter *progressReporter) note(message string) {
+	if reporter.mode == "ndjson" {
+		reporter.emit(progressEvent{Event: "note", Message: message})
+		return
+	}
+	if reporter.mode == "tty" && reporter.drawn {
+		fmt.Fprintln(reporter.out)
+		reporter.drawn = false
+	}
+	fmt.Fprintf(reporter.out, "codebase-memory-mcp: %s\n", message)
+}
+
+// finish ends the report with the outcome of the install.
+func (reporter *progressReporter) finish(err error) {
+	switch reporter.mode {
+	case "ndjson":
+		event := progressEvent{
+			Event:     "done",
+			ElapsedMS: time.Since(reporter.started).Milliseconds(),
+		}
+		if err != nil {
+			event.Event = "error"
+			event.Error = err.Error()
+		}
+		reporter.emit(event)
+	case "tty":
+		if reporter.drawn {
+			fmt.Fprintln(reporter.out)
+			reporter.drawn = false
+		}
+	}
+}
+
+// finishWait ends a wait display without reporting an outcome: the waiter's
+// own install, if any, reports its own.
+func (reporter *progressReporter) finishWait() {
+	if reporter.mode == "tty" && reporter.drawn {
+		fmt.Fprintln(reporter.out)
+		reporter.drawn = false
+	}
+}
+
+func (reporter *progressReporter) emit(event progressEvent) {
+	event.Timestamp = time.Now().UnixMilli()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	reporter.emitted = time.Now()
+	fmt.Fprintf(reporter.out, "%s\n", line)
+}
+
+func (reporter *progressReporter) draw(status string) {
+	reporter.emitted = time.Now()
+	reporter.drawn = true
+	fmt.Fprintf(reporter.out, "\rcodebase-memory-mcp: %s\x1b[K", status)
+}
+
+// rate averages over the current phase, which is steadier than the last
+// interval on a bursty connection.
+func (reporter *progressReporter) rate(
+	now time.Time, done, total int64,
+) (float64, float64) {
+	elapsed := now.Sub(reporter.phaseStart).Seconds()
+	if done <= 0 || elapsed <= 0 {
+		return 0, 0
+	}
+	rate := float64(done) / elapsed
+	if total <= done {
+		return rate, 0
+	}
+	return rate, float64(total-done) / rate
+}
+
+func (reporter *progressReporter) rateText(now time.Time, done, total int64) string {
+	rate, eta := reporter.rate(now, done, total)
+	if rate == 0 {
+		return ""
+	}
+	text := fmt.Sprintf(", %.1f MiB/s", rate/(1024*1024))
+	if eta > 0 {
+		text += fmt.Sprintf(", %s left", time.Duration(eta*float64(time.Second)).Round(time.Second))
+	}
+	return text
+}
+
+var progressPhaseActivity = map[string]string{
+	"checksum": "checking the archive checksum",
+	"extract":  "extracting the runtime",
+	"verify":   "verifying the runtime",
+	"publish":  "publishing the runtime",
+}
+
+func describeProgress(phase string, done, total int64) string {
+	const mebibyte = 1024 * 1024
+	switch {
+	case phase == "download" && total > 0:
+		return fmt.Sprintf(
+			"downloading %.1f of %.1f MiB (%d%%)",
+			float64(done)/mebibyte, float64(total)/mebibyte, done*100/total,
+		)
+	case phase == "download":
+		return fmt.Sprintf("downloading %.1f MiB", float64(done)/mebibyte)
+	}
+	if activity, ok := progressPhaseActivity[phase]; ok {
+		return activity
+	}
+	return "installing the runtime"
+}
+
+func stderrIsTerminal() bool {
+	status, err := os.Stderr.Stat()
+	return err == nil && status.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// embeddedSigstoreTrustedRoot holds the Fulcio certificate authorities and
+// Rekor log keys that release provenance is verified against, in the format
+// of the Sigstore public-good trusted_root.json. It is a copy of that TUF
+// target from the sigstore/root-signing repository, refreshed when Fulcio or
+// Rekor rotate keys; the wrapper never fetches trust roots from the network.
+//
+//go:embed sigstore_trusted_root.json
+var embeddedSigstoreTrustedRoot []byte
+
+// Fulcio certificate extensions carrying the OIDC issuer and the source
+// repository of the workflow that requested the signing certificate.
+var (
+	oidFulcioIssuer              = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidFulcioSourceRepositoryURI = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 12}
+)
+
+// provenanceRecord is what the last install learned about the provenance of
+// its archive. It is kept beside the runtime set for diagnostics.
+type provenanceRecord struct {
+	Status           string `json:"status"`
+	Version          string `json:"version"`
+	Archive          string `json:"archive"`
+	ArchiveSHA256    string `json:"archive_sha256"`
+	Subject          string `json:"subject,omitempty"`
+	BuilderID        string `json:"builder_id,omitempty"`
+	BuildSigner      string `json:"build_signer,omitempty"`
+	SourceRepository string `json:"source_repository,omitempty"`
+	RekorLogIndex    int64  `json:"rekor_log_index,omitempty"`
+	IntegratedTime   int64  `json:"integrated_time_unix_s,omitempty"`
+	VerifiedAt       int64  `json:"verified_at_unix_s,omitempty"`
+}
+
+func provenanceRequired() (bool, error) {
+	switch wrapperSetting("CBM_GO_PROVENANCE") {
+	case "", "off":
+		return false, nil
+	case "require":
+		return true, nil
+	default:
+		return false, fmt.Errorf("CBM_GO_PROVENANCE must be \"off\" or \"require\"")
+	}
+}
+
+type sigstoreValidity struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (validity sigstoreValidity) covers(at time.Time) bool {
+	if !validity.Start.IsZero() && at.Before(validity.Start) {
+		return false
+	}
+	return validity.End.IsZero() || at.Before(validity.End)
+}
+
+type sigstoreRawBytes struct {
+	RawBytes []byte `json:"rawBytes"`
+}
+
+type sigstoreTrustedRoot struct {
+	Tlogs []struct {
+		PublicKey struct {
+			RawBytes []byte           `json:"rawBytes"`
+			ValidFor sigstoreValidity `json:"validFor"`
+		} `json:"publicKey"`
+		LogID struct {
+			KeyID []byte `json:"keyId"`
+		} `json:"logId"`
+	} `json:"tlogs"`
+	CertificateAuthorities []struct {
+		CertChain struct {
+			Certificates []sigstoreRawBytes `json:"certificates"`
+		} `json:"certChain"`
+		ValidFor sigstoreValidity `json:"validFor"`
+	} `json:"certificateAuthorities"`
+}
+
+type sigstoreTlogEntry struct {
+	LogIndex int64 `json:"logIndex,string"`
+	LogID    struct {
+		KeyID []byte `json:"keyId"`
+	} `json:"logId"`
+	KindVersion struct {
+		Kind    string `json:"kind"`
+		Version string `json:"version"`
+	} `json:"kindVersion"`
+	IntegratedTime   int64 `json:"integratedTime,string"`
+	InclusionPromise *struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	CanonicalizedBody []byte `json:"canonicalizedBody"`
+}
+
+type sigstoreBundle struct {
+	VerificationMaterial struct {
+		Certificate          *sigstoreRawBytes `json:"certificate"`
+		X509CertificateChain *struct {
+			Certificates []sigstoreRawBytes `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []sigstoreTlogEntry `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	DSSEEnvelope struct {
+		Payload     []byte `json:"payload"`
+		PayloadType string `json:"payloadType"`
+		Signatures  []struct {
+			Sig []byte `json:"sig"`
+		} `json:"signatures"`
+	} `json:"dsseEnvelope"`
+}
+
+type slsaProvenanceStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		BuildDefinition struct {
+			ExternalParameters struct {
+				Workflow struct {
+					Repository string `json:"repository"`
+				} `json:"workflow"`
+			} `json:"externalParameters"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// verifyReleaseProvenance verifies a Sigstore bundle holding the SLSA
+// provenance of a release entirely offline: the Rekor inclusion promise
+// against the embedded log keys, the Fulcio certificate chain at the time the
+// entry was logged, the DSSE signature, the log entry's binding to that
+// envelope, and finally that the statement names one of subjectDigests and
+// was built by this repository's release workflow on a GitHub-hosted runner.
+func verifyReleaseProvenance(
+	bundleJSON, trustedRootJSON []byte, subjectDigests ...string,
+) (provenanceRecord, error) {
+	var record provenanceRecord
+	var root sigstoreTrustedRoot
+	if err := json.Unmarshal(trustedRootJSON, &root); err != nil {
+		return record, fmt.Errorf("embedded Sigstore trusted root is malformed: %w", err)
+	}
+	if len(root.CertificateAuthorities) == 0 || len(root.Tlogs) == 0 {
+		return record, fmt.Errorf(
+			"this wrapper was built without Sigstore trust roots",
+		)
+	}
+	var bundle sigstoreBundle
+	if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+		return record, fmt.Errorf("provenance bundle is malformed: %w", err)
+	}
+	material := bundle.VerificationMaterial
+	var rawCertificates []sigstoreRawBytes
+	if material.Certificate != nil {
+		rawCertificates = []sigstoreRawBytes{*material.Certificate}
+	} else if material.X509CertificateChain != nil {
+		rawCertificates = material.X509CertificateChain.Certificates
+	}
+	if len(rawCertificates) == 0 {
+		return record, fmt.Errorf("provenance bundle has no signing certificate")
+	}
+	var entry *sigstoreTlogEntry
+	for i := range material.TlogEntries {
+		if material.TlogEntries[i].InclusionPromise != nil {
+			entry = &material.TlogEntries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return record, fmt.Errorf("provenance bundle has no transparency log promise")
+	}
+	integrated := time.Unix(entry.IntegratedTime, 0)
+
+	if err := verifyRekorPromise(root, entry); err != nil {
+		return record, err
+	}
+	leaf, err := verifyFulcioCertificate(root, rawCertificates, integrated)
+	if err != nil {
+		return record, err
+	}
+	repositoryURL := "https://github.com/" + repo
+	if issuer := fulcioExtension(leaf, oidFulcioIssuer); issuer != githubActionsIssuer {
+		return record, fmt.Errorf("provenance was not signed by GitHub Actions: issuer %q", issuer)
+	}
+	source := fulcioExtension(leaf, oidFulcioSourceRepositoryURI)
+	if source != repositoryURL {
+		return record, fmt.Errorf("provenance source repository %q is not %s", source, repositoryURL)
+	}
+	var signer string
+	if len(leaf.URIs) == 1 {
+		signer = leaf.URIs[0].String()
+	}
+	if !strings.HasPrefix(signer, repositoryURL+"/.github/workflows/") {
+		return record, fmt.Errorf("provenance was signed by workflow %q outside %s", signer, repo)
+	}
+
+	envelope := bundle.DSSEEnvelope
+	if envelope.PayloadType != "application/vnd.in-toto+json" {
+		return record, fmt.Errorf("provenance payload type %q is not in-toto", envelope.PayloadType)
+	}
+	publicKey, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return record, fmt.Errorf("provenance signing key type is not supported")
+	}
+	pae := sha256.Sum256(fmt.Appendf(
+		nil, "DSSEv1 %d %s %d %s",
+		len(envelope.PayloadType), envelope.PayloadType,
+		len(envelope.Payload), envelope.Payload,
+	))
+	var signature []byte
+	for _, candidate := range envelope.Signatures {
+		if ecdsa.VerifyASN1(publicKey, pae[:], candidate.Sig) {
+			signature = candidate.Sig
+			break
+		}
+	}
+	if signature == nil {
+		return record, fmt.Errorf("provenance envelope signature verification failed")
+	}
+	if err := verifyRekorDSSEBody(entry, envelope.Payload, signature, leaf); err != nil {
+		return record, err
+	}
+
+	var statement slsaProvenanceStatement
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		return record, fmt.Errorf("provenance statement is malformed: %w", err)
+	}
+	if statement.Type != "https://in-toto.io/Statement/v1" ||
+		statement.PredicateType != "https://slsa.dev/provenance/v1" {
+		return record, fmt.Errorf("provenance statement is not SLSA v1 provenance")
+	}
+	for _, subject := range statement.Subject {
+		digest := strings.ToLower(subject.Digest["sha256"])
+		for _, wanted := range subjectDigests {
+			if digest != "" && digest == wanted {
+				record.Subject = subject.Name
+			}
+		}
+	}
+	if record.Subject == "" {
+		return record, fmt.Errorf("provenance does not cover the downloaded release")
+	}
+	builder := statement.Predicate.RunDetails.Builder.ID
+	if builder != githubHostedBuilderID {
+		return record, fmt.Errorf("provenance builder %q is not %s", builder, githubHostedBuilderID)
+	}
+	workflowRepository := statement.Predicate.BuildDefinition.ExternalParameters.Workflow.Repository
+	if workflowRepository != repositoryURL {
+		return record, fmt.Errorf("provenance workflow repository %q is not %s", workflowRepository, repositoryURL)
+	}
+
+	record.Status = "verified"
+	record.BuilderID = builder
+	record.BuildSigner = signer
+	record.SourceRepository = source
+	record.RekorLogIndex = entry.LogIndex
+	record.IntegratedTime = entry.IntegratedTime
+	record.VerifiedAt = time.Now().Unix()
+	return record, nil
+}
+
+// verifyRekorPromise checks the signed entry timestamp, Rekor's promise to
+// include the entry, over the canonical JSON form Rekor signs.
+func verifyRekorPromise(root sigstoreTrustedRoot, entry *sigstoreTlogEntry) error {
+	integrated := time.Unix(entry.IntegratedTime, 0)
+	for _, log := range root.Tlogs {
+		if !bytes.Equal(log.LogID.KeyID, entry.LogID.KeyID) {
+			continue
+		}
+		if !log.PublicKey.ValidFor.covers(integrated) {
+			return fmt.Errorf("transparency log key was not valid when the provenance was logged")
+		}
+		parsed, err := x509.ParsePKIXPublicKey(log.PublicKey.RawBytes)
+		if err != nil {
+			return fmt.Errorf("embedded transparency log key is malformed: %w", err)
+		}
+		publicKey, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("embedded transparency log key type is not supported")
+		}
+		canonical, err := json.Marshal(struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogID          string `json:"logID"`
+			LogIndex       int64  `json:"logIndex"`
+		}{
+			Body:           base64.StdEncoding.EncodeToString(entry.CanonicalizedBody),
+			IntegratedTime: entry.IntegratedTime,
+			LogID:          hex.EncodeToString(entry.LogID.KeyID),
+			LogIndex:       entry.LogIndex,
+		})
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(canonical)
+		if !ecdsa.VerifyASN1(
+			publicKey, digest[:], entry.InclusionPromise.SignedEntryTimestamp,
+		) {
+			return fmt.Errorf("transparency log promise verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("provenance was logged by an untrusted transparency log")
+}
+
+// verifyFulcioCertificate verifies the short-lived signing certificate at the
+// moment the transparency log accepted it, which is long after it expired.
+func verifyFulcioCertificate(
+	root sigstoreTrustedRoot, rawCertificates []sigstoreRawBytes, at time.Time,
+) (*x509.Certificate, error) {
+	leaf, err := x509.ParseCertificate(rawCertificates[0].RawBytes)
+	if err != nil {
+		return nil, fmt.Errorf("provenance signing certificate is malformed: %w", err)
+	}
+	for _, authority := range root.CertificateAuthorities {
+		chain := authority.CertChain.Certificates
+		if len(chain) == 0 || !authority.ValidFor.covers(at) {
+			continue
+		}
+		roots := x509.NewCertPool()
+		intermediates := x509.NewCertPool()
+		for i, raw := range chain {
+			certificate, err := x509.ParseCertificate(raw.RawBytes)
+			if err != nil {
+				return nil, fmt.Errorf("embedded certificate authority is malformed: %w", err)
+			}
+			if i == len(chain)-1 {
+				roots.AddCert(certificate)
+			} else {
+				intermediates.AddCert(certificate)
+			}
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   at,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		}); err == nil {
+			return leaf, nil
+		}
+	}
+	return nil, fmt.Errorf("provenance signing certificate does not chain to a trusted authority")
+}
+
+// verifyRekorDSSEBody ties the logged entry to this envelope, so a valid
+// promise for some other entry cannot vouch for it.
+func verifyRekorDSSEBody(
+	entry *sigstoreTlogEntry, payload, signature []byte, leaf *x509.Certificate,
+) error {
+	var body struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			PayloadHash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"payloadHash"`
+			Signatures []struct {
+				Signature string `json:"signature"`
+				Verifier  string `json:"verifier"`
+			} `json:"signatures"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(entry.CanonicalizedBody, &body); err != nil {
+		return fmt.Errorf("transparency log entry is malformed: %w", err)
+	}
+	payloadDigest := sha256.Sum256(payload)
+	if body.Kind != "dsse" || body.Spec.PayloadHash.Algorithm != "sha256" ||
+		body.Spec.PayloadHash.Value != hex.EncodeToString(payloadDigest[:]) {
+		return fmt.Errorf("transparency log entry does not record this provenance")
+	}
+	for _, logged := range body.Spec.Signatures {
+		if logged.Signature != base64.StdEncoding.EncodeToString(signature) {
+			continue
+		}
+		verifier, err := base64.StdEncoding.DecodeString(logged.Verifier)
+		if err != nil {
+			continue
+		}
+		block, _ := pem.Decode(verifier)
+		if block != nil && bytes.Equal(block.Bytes, leaf.Raw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("transparency log entry does not record this provenance signature")
+}
+
+func fulcioExtension(certificate *x509.Certificate, oid asn1.ObjectIdentifier) string {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oid) {
+			continue
+		}
+		var value string
+		if _, err := asn1.Unmarshal(extension.Value, &value); err != nil {
+			return ""
+		}
+		return value
+	}
+	return ""
+}
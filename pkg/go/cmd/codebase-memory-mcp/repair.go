@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runtimeRepairStateSize is how many hex digits of the state fingerprint
+// "wrapper repair" prints and --yes must repeat.
+const runtimeRepairStateSize = 16
+
+// runtimeRepairPlan is what "wrapper repair" does with a version whose
+// publication journals the launch path will not resolve on its own: keep the
+// current binary, put back one journal's copy, or drop both so the next
+// launch installs the release again.
+type runtimeRepairPlan struct {
+	action string // "accept", "restore" or "discard"
+	backup string // the journal to restore, by directory name
+}
+
+func (plan runtimeRepairPlan) String() string {
+	if plan.action == "restore" {
+		return "--restore=" + plan.backup
+	}
+	return "--" + plan.action
+}
+
+// parseRuntimeRepairArgs reads "[--accept | --restore=JOURNAL | --discard]
+// [--yes=STATE]", returning the plan and the state it confirms. With no plan,
+// repair only reports and recommends one. A bare --yes is refused: the
+// confirmation must name the state the plan was shown for.
+func parseRuntimeRepairArgs(args []string) (runtimeRepairPlan, string, bool) {
+	var plan runtimeRepairPlan
+	confirmed := ""
+	for _, argument := range args {
+		choice := runtimeRepairPlan{}
+		switch {
+		case strings.HasPrefix(argument, "--yes="):
+			confirmed = strings.TrimPrefix(argument, "--yes=")
+			if confirmed == "" || confirmed != strings.ToLower(confirmed) ||
+				strings.Trim(confirmed, "0123456789abcdef") != "" {
+				return plan, "", false
+			}
+			continue
+		case argument == "--accept", argument == "--discard":
+			choice.action = strings.TrimPrefix(argument, "--")
+		case strings.HasPrefix(argument, "--restore="):
+			choice.action = "restore"
+			choice.backup = strings.TrimPrefix(argument, "--restore=")
+			if !runtimeBackupDirectoryName(choice.backup) {
+				return plan, "", false
+			}
+		default:
+			return plan, "", false
+		}
+		if plan.action != "" {
+			return plan, "", false
+		}
+		plan = choice
+	}
+	if confirmed != "" && plan.action == "" {
+		return plan, "", false
+	}
+	return plan, confirmed, true
+}
+
+// wrapperRepair reports the selected version's binary and every publication
+// journal beside it, with the digests involved, and recommends a plan along
+// with a fingerprint of that state. Given a plan and --yes=STATE it carries
+// the plan out under the runtime-set lock, re-reading the journals once the
+// lock is held and refusing unless they still have the fingerprint the user
+// confirmed, so it acts only on what it showed.
+func wrapperRepair(plan runtimeRepairPlan, confirmed string, stdout io.Writer) error {
+	ver, err := selectedVersion()
+	if err != nil {
+		return err
+	}
+	binary := binPath(ver)
+	directory, binaryName := filepath.Dir(binary), filepath.Base(binary)
+	if err := requireSafeRuntimeDirectory(directory); os.IsNotExist(err) {
+		fmt.Fprintf(stdout, "v%s is not cached; nothing to repair\n", ver)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	verifier := cachedVersionVerifier(ver)
+	if confirmed == "" {
+		backups, err := describeRuntimeRepair(directory, binaryName, ver, verifier, plan, stdout)
+		if err != nil || len(backups) == 0 {
+			return err
+		}
+		if plan.action == "" {
+			plan = recommendRuntimeRepair(directory, binaryName, ver, verifier, backups)
+			fmt.Fprintf(stdout, "recommended: %s\n", plan)
+		}
+		fmt.Fprintf(stdout, "run \"codebase-memory-mcp wrapper repair %s --yes=%s\" to carry it out\n",
+			plan, runtimeRepairState(directory, binaryName, backups))
+		return nil
+	}
+	return withRuntimeSetLock(directory, func(lock *runtimeSetLock) error {
+		backups, err := describeRuntimeRepair(directory, binaryName, ver, verifier, plan, stdout)
+		if err != nil || len(backups) == 0 {
+			return err
+		}
+		if state := runtimeRepairState(directory, binaryName, backups); state != confirmed {
+			return fmt.Errorf(
+				"the binary or journals changed since state %s was shown (now %s); review \"codebase-memory-mcp wrapper repair\" again",
+				confirmed, state,
+			)
+		}
+		if err := applyRuntimeRepair(directory, binaryName, plan, backups, lock); err != nil {
+			return err
+		}
+		_ = os.Remove(filepath.Join(directory, runtimeVerifiedRecordName))
+		if plan.action == "discard" {
+			fmt.Fprintf(stdout, "discarded; the next launch installs v%s again\n", ver)
+			return nil
+		}
+		if problem := verifyCachedRuntimeSet(directory, binaryName, verifier); problem != nil {
+			fmt.Fprintf(stdout, "repaired, but %s does not verify (%v); the next launch installs v%s again\n",
+				binaryName, problem, ver)
+			return nil
+		}
+		recordVerifiedRuntimeSet(directory, binaryName)
+		fmt.Fprintf(stdout, "repaired; %s verifies\n", binaryName)
+		return nil
+	})
+}
+
+// describeRuntimeRepair prints the current binary and each journal. A journal
+// marked retired was taken while replacing a published set, so its copy is
+// the set that was running before; one marked cleanup-only was already
+// settled and only its deletion was interrupted.
+func describeRuntimeRepair(
+	directory, binaryName, ver string, verifier func(string) error,
+	plan runtimeRepairPlan, stdout io.Writer,
+) ([]*runtimeBackupTransaction, error) {
+	backups, err := listRuntimeBackupDirectories(directory, binaryName)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(stdout, "v%s has no publication journals; nothing to repair\n", ver)
+		return nil, nil
+	}
+	if _, err := currentRuntimeTargets(directory, binaryName); err != nil {
+		return nil, err
+	}
+	published := publishedBinaryDigests(ver, binaryName)
+	target := filepath.Join(directory, binaryName)
+	fmt.Fprintf(stdout, "v%s in %s\n", ver, directory)
+	if regularRuntimeFile(target) {
+		state := "verifies"
+		if problem := verifier(target); problem != nil {
+			state = fmt.Sprintf("does not verify: %v", problem)
+		}
+		fmt.Fprintf(stdout, "current %s: sha256 %s%s, %s\n",
+			binaryName, fileDigest(target), publishedNote(fileDigest(target), published), state)
+	} else {
+		fmt.Fprintf(stdout, "current %s: missing\n", binaryName)
+	}
+	for _, backup := range backups {
+		var markers []string
+		if backup.retired {
+			markers = append(markers, "retired")
+		}
+		if backup.cleanupOnly {
+			markers = append(markers, "cleanup-only")
+		}
+		fmt.Fprintf(stdout, "journal %s", filepath.Base(backup.path))
+		if len(markers) > 0 {
+			fmt.Fprintf(stdout, " [%s]", strings.Join(markers, ", "))
+		}
+		fmt.Fprintln(stdout)
+		if _, saved := backup.files[binaryName]; !saved {
+			fmt.Fprintf(stdout, "  %s: not saved; restoring removes the current one\n", binaryName)
+		}
+		for _, name := range runtimeRepairMembers(backup, binaryName) {
+			member := backup.files[name]
+			digest := hex.EncodeToString(member.digest[:])
+			notes := ""
+			if name == binaryName {
+				notes = publishedNote(digest, published)
+			}
+			if pathMatchesSHA256(filepath.Join(directory, name), member.digest) {
+				notes += ", same as current"
+			}
+			fmt.Fprintf(stdout, "  %s: sha256 %s%s\n", name, digest, notes)
+		}
+	}
+	fmt.Fprintf(stdout, "state: %s\n", runtimeRepairState(directory, binaryName, backups))
+	if plan.action == "restore" && !slices.ContainsFunc(backups, func(backup *runtimeBackupTransaction) bool {
+		return filepath.Base(backup.path) == plan.backup
+	}) {
+		return nil, fmt.Errorf("no publication journal named %s", plan.backup)
+	}
+	if plan.action == "accept" && !regularRuntimeFile(target) {
+		return nil, fmt.Errorf("there is no current %s to accept; restore a journal or discard", binaryName)
+	}
+	fmt.Fprintln(stdout, "plans:")
+	fmt.Fprintln(stdout, "  --accept              keep the current binary and delete every journal")
+	fmt.Fprintln(stdout, "  --restore=JOURNAL     put back that journal's binary and delete every journal")
+	fmt.Fprintf(stdout, "  --discard             delete the binary and every journal; the next launch installs v%s again\n", ver)
+	return backups, nil
+}
+
+// runtimeRepairMembers lists a journal's saved files, the binary first and
+// the rest by name.
+func runtimeRepairMembers(backup *runtimeBackupTransaction, binaryName string) []string {
+	names := slices.Sorted(maps.Keys(backup.files))
+	if index := slices.Index(names, binaryName); index > 0 {
+		names = slices.Insert(slices.Delete(names, index, index+1), 0, binaryName)
+	}
+	return names
+}
+
+// runtimeRepairState fingerprints what a repair plan acts on: the current
+// binary's digest and every journal's name, markers and member digests.
+// "wrapper repair" prints it with the plan and --yes must repeat it, so a
+// confirmation never applies to journals that changed after they were shown.
+func runtimeRepairState(
+	directory, binaryName string, backups []*runtimeBackupTransaction,
+) string {
+	hash := sha256.New()
+	current := "missing"
+	if target := filepath.Join(directory, binaryName); regularRuntimeFile(target) {
+		current = fileDigest(target)
+	}
+	fmt.Fprintf(hash, "current %s %s\n", binaryName, current)
+	ordered := slices.Clone(backups)
+	slices.SortFunc(ordered, func(left, right *runtimeBackupTransaction) int {
+		return strings.Compare(filepath.Base(left.path), filepath.Base(right.path))
+	})
+	for _, backup := range ordered {
+		fmt.Fprintf(hash, "journal %s retired=%t cleanup-only=%t\n",
+			filepath.Base(backup.path), backup.retired, backup.cleanupOnly)
+		for _, name := range slices.Sorted(maps.Keys(backup.files)) {
+			member := backup.files[name]
+			fmt.Fprintf(hash, "member %s %s\n", name, hex.EncodeToString(member.digest[:]))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:runtimeRepairStateSize]
+}
+
+// recommendRuntimeRepair prefers, in order, a current binary that verifies,
+// a journal holding a binary the release publishes, and a fresh install.
+// A journal's copy is never run to find out.
+func recommendRuntimeRepair(
+	directory, binaryName, ver string, verifier func(string) error,
+	backups []*runtimeBackupTransaction,
+) runtimeRepairPlan {
+	if runtimeSetReady(directory, binaryName, verifier) {
+		return runtimeRepairPlan{action: "accept"}
+	}
+	published := publishedBinaryDigests(ver, binaryName)
+	for _, backup := range backups {
+		if member, saved := backup.files[binaryName]; saved &&
+			slices.Contains(published, hex.EncodeToString(member.digest[:])) {
+			return runtimeRepairPlan{action: "restore", backup: filepath.Base(backup.path)}
+		}
+	}
+	return runtimeRepairPlan{action: "discard"}
+}
+
+func applyRuntimeRepair(
+	directory, binaryName string, plan runtimeRepairPlan,
+	backups []*runtimeBackupTransaction, lock *runtimeSetLock,
+) error {
+	target := filepath.Join(directory, binaryName)
+	switch plan.action {
+	case "restore":
+		index := slices.IndexFunc(backups, func(backup *runtimeBackupTransaction) bool {
+			return filepath.Base(backup.path) == plan.backup
+		})
+		member, saved := backups[index].files[binaryName]
+		switch {
+		case !saved && regularRuntimeFile(target):
+			if err := assertRuntimeSetLockOwner(lock); err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		case saved && !pathMatchesSHA256(target, member.digest):
+			if err := copyRuntimeBackupFile(member, target, true, lock); err != nil {
+				return err
+			}
+			if !pathMatchesSHA256(target, member.digest) {
+				return fmt.Errorf("package-cache backup restoration failed: %s", binaryName)
+			}
+		}
+	case "discard":
+		if regularRuntimeFile(target) {
+			if err := assertRuntimeSetLockOwner(lock); err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+	}
+	for _, backup := range backups {
+		if err := cleanupRuntimeBackup(backup, lock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishedBinaryDigests are the binary digests this wrapper pins for
+// release ver. Repair works offline, so a release it does not pin gets none.
+func publishedBinaryDigests(ver, binaryName string) []string {
+	if ver != version {
+		return nil
+	}
+	checksums, err := embeddedReleaseDigests(embeddedReleaseChecksums, ver)
+	if err != nil {
+		return nil
+	}
+	var digests []string
+	for _, archive := range platformReleaseArchives() {
+		if digest, listed := checksums[archive+"/"+binaryName]; listed {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
+
+func publishedNote(digest string, published []string) string {
+	if slices.Contains(published, digest) {
+		return " (published)"
+	}
+	return ""
+}
+
+func fileDigest(path string) string {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "unreadable"
+	}
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	repoConfigDirectory = ".codebase-memory"
+	repoConfigName      = "wrapper.json"
+	maxRepoConfigSize   = 64 * 1024
+	maxDaemonLogScan    = int64(16 * 1024 * 1024)
+)
+
+// repoConfig is a repository's .codebase-memory/wrapper.json, which lets a
+// team run one engine build on every checkout:
+//
+//	{
+//	  "version": "0.8.1",
+//	  "mirror": "https://mirror.example.com/codebase-memory-mcp",
+//	  "env": {"CBM_LOG_LEVEL": "info"}
+//	}
+//
+// Every field is a default: the process environment still wins. A checked-in
+// file is input from whoever controls the repository, so env may only set the
+// engine tuning knobs in repoConfigEnvAllowed, never a path, URL or wrapper
+// setting. Its mirror is ignored unless the user opts in with
+// CBM_GO_ALLOW_REPO_MIRROR=1, and a CBM_GO_MIRROR_TOKEN is never sent to it.
+type repoConfig struct {
+	Version string            `json:"version"`
+	Mirror  string            `json:"mirror"`
+	Env     map[string]string `json:"env"`
+
+	path string
+}
+
+// repoConfigEnvAllowed lists the CBM_* variables a repository may default.
+// Each only tunes how the engine indexes, logs or renders; none names a
+// file, directory, URL or credential, or reaches the wrapper.
+var repoConfigEnvAllowed = map[string]bool{
+	"CBM_INDEX_MAX_RESTARTS":     true,
+	"CBM_INDEX_SINGLE_THREAD":    true,
+	"CBM_INDEX_WORKER_TIMEOUT_S": true,
+	"CBM_LOG_FORMAT":             true,
+	"CBM_LOG_LEVEL":              true,
+	"CBM_LSP_DISABLED":           true,
+	"CBM_LSP_MAX_WALK_DEPTH":     true,
+	"CBM_MAX_FILE_BYTES":         true,
+	"CBM_MEM_BUDGET_MB":          true,
+	"CBM_SEMANTIC_ENABLED":       true,
+	"CBM_SEMANTIC_THRESHOLD":     true,
+	"CBM_UI_MAX_RENDER_NODES":    true,
+	"CBM_WATCHER_PRUNE_GRACE_S":  true,
+	"CBM_WORKERS":                true,
+}
+
+// activeRepoConfig is loaded once by main, before any setting is read. Tests
+// that do not set it see the process environment alone.
+var activeRepoConfig repoConfig
+
+// findRepoConfig walks up from start to the first directory holding
+// .codebase-memory/wrapper.json. The walk ends at the repository root, so a
+// checkout never picks up a file from an unrelated parent directory.
+func findRepoConfig(start string) (string, bool) {
+	directory, err := filepath.Abs(start)
+	if err != nil {
+		return "", false
+	}
+	for {
+		candidate := filepath.Join(directory, repoConfigDirectory, repoConfigName)
+		if status, err := os.Stat(candidate); err == nil && status.Mode().IsRegular() {
+			return candidate, true
+		}
+		if _, err := os.Lstat(filepath.Join(directory, ".git")); err == nil {
+			return "", false
+		}
+		parent := filepath.Dir(directory)
+		if parent == directory {
+			return "", false
+		}
+		directory = parent
+	}
+}
+
+func loadRepoConfig(path string) (repoConfig, error) {
+	config := repoConfig{path: path}
+	body, err := readLocalDocument(path, maxRepoConfigSize)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	if decoder.More() {
+		return config, fmt.Errorf("%s: trailing data after the configuration", path)
+	}
+	config.Version = strings.TrimSpace(config.Version)
+	config.Mirror = strings.TrimSpace(config.Mirror)
+	if config.Version != "" {
+		if _, err := parseReleaseVersion(config.Version); err != nil {
+			return config, fmt.Errorf("%s: version: %w", path, err)
+		}
+	}
+	if config.Mirror != "" {
+		if err := validateURLScheme(config.Mirror); err != nil {
+			return config, fmt.Errorf("%s: mirror: %w", path, err)
+		}
+	}
+	for name, value := range config.Env {
+		if err := validateRepoConfigEnv(name, value); err != nil {
+			return config, fmt.Errorf("%s: env: %w", path, err)
+		}
+	}
+	return config, nil
+}
+
+func validateRepoConfigEnv(name, value string) error {
+	if !repoConfigEnvAllowed[name] {
+		return fmt.Errorf(
+			"%s cannot be set by a repository; only engine tuning settings such as CBM_LOG_LEVEL and CBM_WORKERS can, and anything else belongs in the environment",
+			name,
+		)
+	}
+	if strings.ContainsRune(value, 0) {
+		return fmt.Errorf("%s contains a NUL byte", name)
+	}
+	return nil
+}
+
+// applyRepoConfig finds and loads the repository configuration for the
+// working directory. Its env entries become environment defaults, so the
+// native binary inherits them exactly as if they had been exported.
+func applyRepoConfig() error {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	path, found := findRepoConfig(workingDirectory)
+	if !found {
+		return nil
+	}
+	config, err := loadRepoConfig(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range config.Env {
+		if _, set := os.LookupEnv(name); !set {
+			if err := os.Setenv(name, value); err != nil {
+				return fmt.Errorf("%s: env: %w", path, err)
+			}
+		}
+	}
+	if config.Mirror != "" && !repoMirrorAllowed() && os.Getenv("CBM_GO_MIRROR") == "" {
+		fmt.Fprintf(os.Stderr,
+			"codebase-memory-mcp: warning: ignoring the mirror in %s; set CBM_GO_ALLOW_REPO_MIRROR=1 to download from it\n",
+			path,
+		)
+	}
+	activeRepoConfig = config
+	return nil
+}
+
+// repoMirrorAllowed reports whether the user opted in, through the
+// environment a repository cannot set, to downloading from the mirror a
+// repository configuration names.
+func repoMirrorAllowed() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CBM_GO_ALLOW_REPO_MIRROR"))) {
+	case "1", "true", "on":
+		return true
+	}
+	return false
+}
+
+// setting returns the configuration's default for a wrapper setting.
+func (config repoConfig) setting(name string) string {
+	switch name {
+	case "CBM_GO_VERSION":
+		return config.Version
+	case "CBM_GO_MIRROR":
+		if repoMirrorAllowed() {
+			return config.Mirror
+		}
+	}
+	return ""
+}
+
+// wrapperSettingSource returns a wrapper setting with what supplied it: the
+// variable's own name, or the repository configuration file.
+func wrapperSettingSource(name string) (string, string) {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value, name
+	}
+	if value := activeRepoConfig.setting(name); value != "" {
+		return value, activeRepoConfig.path
+	}
+	return "", ""
+}
+
+// runningDaemon is the live daemon generation announced in cbm-daemon.log.
+type runningDaemon struct {
+	Version string
+	PID     int
+}
+
+// nativeCacheDir mirrors cbm_resolve_cache_dir: unlike the wrapper's own
+// cache, the native cache is under the home directory on every platform.
+func nativeCacheDir() string {
+	if directory := os.Getenv("CBM_CACHE_DIR"); directory != "" {
+		return directory
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".cache", "codebase-memory-mcp")
+}
+
+// findRunningDaemon reads the last daemon.start event from the daemon log and
+// reports it only while its process is still alive. The log is rotated when
+// a daemon opens it, so a live daemon's start event is always in the current
+// file.
+func findRunningDaemon(cache string) (runningDaemon, bool) {
+	if cache == "" {
+		return runningDaemon{}, false
+	}
+	file, err := os.Open(filepath.Join(cache, "logs", "cbm-daemon.log"))
+	if err != nil {
+		return runningDaemon{}, false
+	}
+	defer file.Close()
+	var latest runningDaemon
+	found := false
+	scanner := bufio.NewScanner(io.LimitReader(file, maxDaemonLogScan))
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for scanner.Scan() {
+		if daemon, ok := parseDaemonStart(scanner.Text()); ok {
+			latest, found = daemon, true
+		}
+	}
+	if !found || !runtimeSetProcessAlive(latest.PID) {
+		return runningDaemon{}, false
+	}
+	return latest, true
+}
+
+// parseDaemonStart reads a daemon.start event in either of the native log
+// formats: "level=info msg=daemon.start version=... pid=..." or the JSON form.
+func parseDaemonStart(line string) (runningDaemon, bool) {
+	fields := map[string]string{}
+	if strings.HasPrefix(line, "{") {
+		if json.Unmarshal([]byte(line), &fields) != nil {
+			return runningDaemon{}, false
+		}
+		fields["msg"] = fields["event"]
+	} else {
+		for _, field := range strings.Fields(line) {
+			if key, value, ok := strings.Cut(field, "="); ok {
+				fields[key] = value
+			}
+		}
+	}
+	if fields["msg"] != "daemon.start" {
+		return runningDaemon{}, false
+	}
+	pid, err := strconv.Atoi(fields["pid"])
+	if err != nil || pid <= 0 || fields["version"] == "" {
+		return runningDaemon{}, false
+	}
+	return runningDaemon{Version: strings.TrimPrefix(fields["version"], "v"), PID: pid}, true
+}
+
+// checkDaemonVersion refuses a pinned version that a running daemon would
+// reject anyway. The daemon refuses clients of another version, and that
+// surfaces much later as an opaque connection failure in the MCP client.
+func checkDaemonVersion(ver string) error {
+	_, source := wrapperSettingSource("CBM_GO_VERSION")
+	if source == "" {
+		return nil
+	}
+	daemon, running := findRunningDaemon(nativeCacheDir())
+	if !running || daemon.Version == ver {
+		return nil
+	}
+	return fmt.Errorf(
+		"%s pins v%s, but a v%s daemon is running as process %d; stop it with \"CBM_GO_VERSION=%s codebase-memory-mcp daemon stop\" or change the pin to v%s",
+		source, ver, daemon.Version, daemon.PID, daemon.Version, daemon.Version,
+	)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	runtimeLastUsedName = ".cbm-last-used"
+	runtimeLaunchPrefix = ".cbm-launch-"
+	defaultKeepVersions = 3
+	defaultKeepDays     = 30
+)
+
+// retentionPolicy decides which cached versions automatic collection keeps.
+// A version survives when it is active, among the keepVersions most recently
+// used, or used within keepFor; collection removes only what fails all three.
+type retentionPolicy struct {
+	keepVersions int
+	keepFor      time.Duration
+}
+
+// retentionPolicyFromSettings reads CBM_GO_KEEP_VERSIONS (a count of at least
+// one, or "all" to turn collection off) and CBM_GO_KEEP_DAYS (zero or more).
+func retentionPolicyFromSettings() (retentionPolicy, bool, error) {
+	policy := retentionPolicy{
+		keepVersions: defaultKeepVersions,
+		keepFor:      defaultKeepDays * 24 * time.Hour,
+	}
+	switch setting := wrapperSetting("CBM_GO_KEEP_VERSIONS"); setting {
+	case "":
+	case "all":
+		return policy, false, nil
+	default:
+		count, err := strconv.Atoi(setting)
+		if err != nil || count < 1 {
+			return policy, false, fmt.Errorf(
+				"CBM_GO_KEEP_VERSIONS must be a number of at least 1, or \"all\"",
+			)
+		}
+		policy.keepVersions = count
+	}
+	if setting := wrapperSetting("CBM_GO_KEEP_DAYS"); setting != "" {
+		days, err := strconv.Atoi(setting)
+		if err != nil || days < 0 {
+			return policy, false, fmt.Errorf(
+				"CBM_GO_KEEP_DAYS must be a whole number of days",
+			)
+		}
+		policy.keepFor = time.Duration(days) * 24 * time.Hour
+	}
+	return policy, true, nil
+}
+
+// markRuntimeLaunch records, before a launch resolves its runtime set, that
+// this process is about to run the binary in directory: the last-used stamp
+// feeds retention, and the per-process marker keeps collection away from a
+// version while it executes. Both are best effort, and neither is written
+// into a directory that fails requireSafeRuntimeDirectory. Markers of exited
+// launchers are removed first, so a version that is launched often but never
+// collected does not gather one per process. Marking first means a collector
+// either sees the marker or has already finished, in which case the launch
+// finds the set gone and reinstalls it.
+func markRuntimeLaunch(directory string) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return
+	}
+	if requireSafeRuntimeDirectory(directory) != nil {
+		return
+	}
+	removeExitedRuntimeLaunchMarkers(directory)
+	now := time.Now()
+	for _, name := range []string{
+		runtimeLastUsedName, runtimeLaunchPrefix + strconv.Itoa(os.Getpid()),
+	} {
+		path := filepath.Join(directory, name)
+		if err := os.Chtimes(path, now, now); err == nil {
+			continue
+		}
+		if file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644); err == nil {
+			_ = file.Close()
+		}
+	}
+}
+
+// runtimeLastUsed is when a version was last launched. Versions installed
+// before the stamp existed fall back to their binary's modification time.
+func runtimeLastUsed(directory, binaryName string) time.Time {
+	for _, name := range []string{runtimeLastUsedName, binaryName} {
+		if status, err := os.Lstat(filepath.Join(directory, name)); err == nil {
+			return status.ModTime()
+		}
+	}
+	if status, err := os.Lstat(directory); err == nil {
+		return status.ModTime()
+	}
+	return time.Time{}
+}
+
+// runtimeVersionInUse reports a live process that launched the version in
+// directory. Markers of exited processes are removed on the way. A reused
+// PID keeps a version a little longer, which is the safe direction.
+func runtimeVersionInUse(directory string) (int, bool) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return 0, false
+	}
+	for _, entry := range entries {
+		pidText, ok := strings.CutPrefix(entry.Name(), runtimeLaunchPrefix)
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(pidText)
+		if err == nil && pid == os.Getpid() {
+			return pid, true
+		}
+		if err == nil && runtimeSetProcessAlive(pid) {
+			return pid, true
+		}
+		_ = os.Remove(filepath.Join(directory, entry.Name()))
+	}
+	return 0, false
+}
+
+// removeExitedRuntimeLaunchMarkers removes the launch markers of processes
+// that are no longer running. A marker whose PID was reused stays, which
+// only keeps its version a little longer.
+func removeExitedRuntimeLaunchMarkers(directory string) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pidText, ok := strings.CutPrefix(entry.Name(), runtimeLaunchPrefix)
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(pidText)
+		if err == nil && (pid == os.Getpid() || runtimeSetProcessAlive(pid)) {
+			continue
+		}
+		_ = os.Remove(filepath.Join(directory, entry.Name()))
+	}
+}
+
+// retiredVersions applies policy to versions, ranking them by last use.
+func retiredVersions(
+	versions []string, lastUsed map[string]time.Time, active map[string]bool,
+	policy retentionPolicy, now time.Time,
+) []string {
+	ordered := slices.Clone(versions)
+	slices.SortStableFunc(ordered, func(left, right string) int {
+		return lastUsed[right].Compare(lastUsed[left])
+	})
+	var retired []string
+	for index, ver := range ordered {
+		if active[ver] || index < policy.keepVersions ||
+			now.Sub(lastUsed[ver]) < policy.keepFor {
+			continue
+		}
+		retired = append(retired, ver)
+	}
+	return retired
+}
+
+// emptyRuntimeVersionDirectory removes a version's files while its lock is
+// held, leaving only the lock protocol's own files for the release that
+// follows, which then also unlinks the kernel lock file.
+func emptyRuntimeVersionDirectory(directory string, lock *runtimeSetLock) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == runtimeSetLockName || entry.Name() == runtimeSetKernelLockName ||
+			runtimeSetLockProtocolName(entry.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(directory, entry.Name())); err != nil {
+			return err
+		}
+	}
+	lock.retired = true
+	return nil
+}
+
+// collectRuntimeGarbage removes cached versions the retention policy no
+// longer keeps. It runs after a successful install and never waits: a version
+// whose lock is held, whose binary a live process launched, or which a
+// running daemon was started from is skipped and reconsidered next time.
+func collectRuntimeGarbage(cache string) ([]string, error) {
+	policy, enabled, err := retentionPolicyFromSettings()
+	if err != nil || !enabled {
+		return nil, err
+	}
+	versions, err := cachedVersions(cache)
+	if err != nil {
+		return nil, err
+	}
+	binaryName := binaryNameForOS(runtime.GOOS)
+	lastUsed := make(map[string]time.Time, len(versions))
+	for _, ver := range versions {
+		lastUsed[ver] = runtimeLastUsed(filepath.Join(cache, ver), binaryName)
+	}
+	var removed []string
+	for _, ver := range retiredVersions(
+		versions, lastUsed, retainedVersions(), policy, time.Now(),
+	) {
+		directory := filepath.Join(cache, ver)
+		if requireSafeRuntimeDirectory(directory) != nil {
+			continue
+		}
+		lock, acquired, err := tryAcquireRuntimeSetLock(directory)
+		if err != nil || !acquired {
+			continue
+		}
+		if _, inUse := runtimeVersionInUse(directory); inUse {
+			_ = releaseRuntimeSetLock(lock)
+			continue
+		}
+		emptyErr := emptyRuntimeVersionDirectory(directory, lock)
+		if releaseErr := releaseRuntimeSetLock(lock); emptyErr != nil || releaseErr != nil {
+			continue
+		}
+		removeRetiredRuntimeDirectory(directory)
+		removed = append(removed, ver)
+	}
+	return removed, nil
+}
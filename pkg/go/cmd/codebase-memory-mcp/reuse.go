@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	runtimeReuseRecordName = ".cbm-reuse.json"
+	maxRuntimeReuseRecord  = 64 * 1024
+)
+
+// installedBinaryStamp identifies an installed binary as it was when it was
+// checked, so an unchanged file is never hashed or run twice.
+type installedBinaryStamp struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime_unix_ns"`
+	FileID  string `json:"file_id"`
+}
+
+// runtimeReuseRecord sits in a version's cache directory in place of a
+// runtime set: the installed binary confirmed to be that exact release, and
+// the candidates already found not to be.
+type runtimeReuseRecord struct {
+	WrapperVersion string                 `json:"wrapper_version"`
+	Reused         *installedBinaryStamp  `json:"reused,omitempty"`
+	SHA256         string                 `json:"sha256,omitempty"`
+	Rejected       []installedBinaryStamp `json:"rejected,omitempty"`
+}
+
+// reuseEnabled reads CBM_GO_REUSE: "on" lets a launch run a copy of the
+// release that Homebrew, a distribution package or the managed install
+// already put on this machine, instead of caching a second one.
+func reuseEnabled() (bool, error) {
+	switch setting := wrapperSetting("CBM_GO_REUSE"); setting {
+	case "", "off":
+		return false, nil
+	case "on":
+		return true, nil
+	default:
+		return false, fmt.Errorf("CBM_GO_REUSE must be \"on\" or \"off\"")
+	}
+}
+
+// reuseInstalledBinary returns an installed binary that is exactly release
+// ver, when reuse is on and one exists. A candidate is accepted only if its
+// SHA-256 is a digest the release publishes for this platform's binary, from
+// the wrapper's embedded pins or the release manifest, and it then reports
+// version ver. Failures only cost the reuse: the launch falls back to the
+// cache as if reuse were off.
+func reuseInstalledBinary(directory, ver string) (string, bool) {
+	enabled, err := reuseEnabled()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codebase-memory-mcp: %v\n", err)
+		return "", false
+	}
+	if !enabled {
+		return "", false
+	}
+	record := readRuntimeReuseRecord(directory)
+	if record.Reused != nil {
+		if stamp, ok := stampInstalledBinary(record.Reused.Path); ok && stamp == *record.Reused {
+			return stamp.Path, true
+		}
+	}
+	binaryName := binaryNameForOS(goos())
+	var unchecked, rejected []installedBinaryStamp
+	for _, candidate := range installedBinaryCandidates(binaryName) {
+		stamp, ok := stampInstalledBinary(candidate)
+		switch {
+		case !ok:
+		case slices.Contains(record.Rejected, stamp):
+			rejected = append(rejected, stamp)
+		default:
+			unchecked = append(unchecked, stamp)
+		}
+	}
+	if len(unchecked) == 0 {
+		return "", false
+	}
+	var reused string
+	err = withRuntimeSetLock(directory, func(*runtimeSetLock) error {
+		digests, err := releaseBinaryDigests(ver, binaryName)
+		if err != nil {
+			return err
+		}
+		record = runtimeReuseRecord{WrapperVersion: version, Rejected: rejected}
+		verifier := cachedVersionVerifier(ver)
+		for _, stamp := range unchecked {
+			digest, ok := confirmInstalledBinary(stamp, digests, verifier)
+			if !ok {
+				record.Rejected = append(record.Rejected, stamp)
+				continue
+			}
+			record.Reused, record.SHA256 = &stamp, digest
+			reused = stamp.Path
+			break
+		}
+		return writeJSONFileAtomic(filepath.Join(directory, runtimeReuseRecordName), record)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codebase-memory-mcp: not reusing an installed binary: %v\n", err)
+		return "", false
+	}
+	return reused, reused != ""
+}
+
+func readRuntimeReuseRecord(directory string) runtimeReuseRecord {
+	var record runtimeReuseRecord
+	contents, err := readLocalDocument(
+		filepath.Join(directory, runtimeReuseRecordName), maxRuntimeReuseRecord,
+	)
+	if err != nil || json.Unmarshal(contents, &record) != nil ||
+		record.WrapperVersion != version {
+		return runtimeReuseRecord{}
+	}
+	return record
+}
+
+// installedBinaryCandidates lists where an installed copy may be, most
+// specific first: CBM_GO_REUSE_PATHS (binaries or directories), the managed
+// install directory, then PATH. Symlinks such as Homebrew's are resolved,
+// and the wrapper itself and the wrapper's own cache are skipped.
+func installedBinaryCandidates(binaryName string) []string {
+	var locations []string
+	for _, location := range filepath.SplitList(wrapperSetting("CBM_GO_REUSE_PATHS")) {
+		if status, err := os.Stat(location); err == nil && status.IsDir() {
+			location = filepath.Join(location, binaryName)
+		}
+		locations = append(locations, location)
+	}
+	locations = append(locations, filepath.Join(defaultManagedInstallDir(), binaryName))
+	for _, directory := range filepath.SplitList(os.Getenv("PATH")) {
+		if directory != "" {
+			locations = append(locations, filepath.Join(directory, binaryName))
+		}
+	}
+	self, _ := os.Executable()
+	selfStatus, selfErr := os.Stat(self)
+	cache, _ := filepath.Abs(cacheDir())
+	var candidates []string
+	for _, location := range locations {
+		resolved, err := filepath.EvalSymlinks(location)
+		if err != nil {
+			continue
+		}
+		if resolved, err = filepath.Abs(resolved); err != nil {
+			continue
+		}
+		if slices.Contains(candidates, resolved) ||
+			strings.HasPrefix(resolved, cache+string(filepath.Separator)) {
+			continue
+		}
+		if status, err := os.Stat(resolved); err != nil || !status.Mode().IsRegular() ||
+			(selfErr == nil && os.SameFile(status, selfStatus)) {
+			continue
+		}
+		candidates = append(candidates, resolved)
+	}
+	return candidates
+}
+
+func stampInstalledBinary(path string) (installedBinaryStamp, bool) {
+	status, err := os.Lstat(path)
+	if err != nil || !status.Mode().IsRegular() {
+		return installedBinaryStamp{}, false
+	}
+	identity := platformRuntimeFileIdentity(path, status)
+	if identity == "" {
+		return installedBinaryStamp{}, false
+	}
+	return installedBinaryStamp{
+		Path:    path,
+		Size:    status.Size(),
+		ModTime: status.ModTime().UnixNano(),
+		FileID:  identity,
+	}, true
+}
+
+// confirmInstalledBinary checks a candidate's digest before running it, so
+// nothing but a published release binary is ever executed, and then checks
+// that the file did not change while it was being hashed and run.
+func confirmInstalledBinary(
+	stamp installedBinaryStamp, digests []string, verifier func(string) error,
+) (string, bool) {
+	sum, err := fileSHA256(stamp.Path)
+	if err != nil {
+		return "", false
+	}
+	digest := hex.EncodeToString(sum[:])
+	if !slices.Contains(digests, digest) {
+		return "", false
+	}
+	if verifier != nil && verifier(stamp.Path) != nil {
+		return "", false
+	}
+	if after, ok := stampInstalledBinary(stamp.Path); !ok || after != stamp {
+		return "", false
+	}
+	return digest, true
+}
+
+// releaseBinaryDigests returns the published SHA-256 digests of this
+// platform's binaries for release ver. A manifest lists them as archive
+// members, "<archive>/<binary>", beside the archive digests; release.yml adds
+// them through scripts/ci/append-binary-member-checksums.sh. This wrapper's
+// own release uses its embedded pins; any other release needs its manifest to
+// carry those entries, so one published before them is downloaded instead.
+func releaseBinaryDigests(ver, binaryName string) ([]string, error) {
+	archives := platformReleaseArchives()
+	var checksums map[string]string
+	if ver == version {
+		embedded, err := embeddedReleaseDigests(embeddedReleaseChecksums, ver)
+		if err != nil {
+			return nil, err
+		}
+		checksums = embedded
+	}
+	if !listsReleaseBinary(checksums, archives, binaryName) {
+		var err error
+		checksums, err = releaseManifest(ver, archives)
+		if err != nil {
+			return nil, fmt.Errorf("checksum manifest unavailable: %w", err)
+		}
+	}
+	var digests []string
+	for _, archive := range archives {
+		if digest, listed := checksums[archive+"/"+binaryName]; listed {
+			digests = append(digests, digest)
+		}
+	}
+	if len(digests) == 0 {
+		return nil, fmt.Errorf(
+			"v%s publishes no binary digest to confirm an installed copy against", ver,
+		)
+	}
+	return digests, nil
+}
+
+// platformReleaseArchives names every archive a release publishes for this
+// platform, whichever build a launch would choose.
+func platformReleaseArchives() []string {
+	platform, arch := goos(), goarch()
+	variants := []string{""}
+	if platform == "linux" {
+		variants = []string{linuxVariantPortable, linuxVariantNative}
+	}
+	archives := make([]string, len(variants))
+	for index, variant := range variants {
+		archives[index] = releaseArchiveName(platform, arch, variant)
+	}
+	return archives
+}
+
+func listsReleaseBinary(checksums map[string]string, archives []string, binaryName string) bool {
+	for _, archive := range archives {
+		if _, listed := checksums[archive+"/"+binaryName]; listed {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseManifest reads release ver's checksums.txt from wherever an install
+// would: CBM_GO_ARCHIVE's directory or the configured release source.
+func releaseManifest(ver string, archives []string) (map[string]string, error) {
+	if local := wrapperSetting("CBM_GO_ARCHIVE"); local != "" {
+		_, manifestPath, err := localReleaseFiles(local, archives)
+		if err != nil {
+			return nil, err
+		}
+		checksums, _, err := readChecksumManifest(manifestPath, ver)
+		return checksums, err
+	}
+	source, err := releaseSourceFromSettings()
+	if err != nil {
+		return nil, err
+	}
+	if err := configureReleaseNetwork(); err != nil {
+		return nil, err
+	}
+	checksums, _, err := fetchChecksums(
+		source.assetURL(ver, "checksums.txt"), ver, source.credential,
+	)
+	return checksums, err
+}
@@ -42,3 +42,13 @@ func platformRuntimeSetFileLinkCountOne(_ string, info os.FileInfo) bool {
 	status, ok := info.Sys().(*syscall.Stat_t)
 	return ok && status.Nlink == 1
 }
+
+// platformRuntimeFileIdentity names the file object behind path, so a
+// replaced binary is detected even when its size and mtime match.
+func platformRuntimeFileIdentity(_ string, info os.FileInfo) string {
+	status, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", status.Dev, status.Ino)
+}
@@ -237,3 +237,24 @@ func platformRuntimeSetFileLinkCountOne(path string, _ os.FileInfo) bool {
 	}
 	return information.NumberOfLinks == 1
 }
+
+// platformRuntimeFileIdentity names the file object behind path by volume
+// serial number and file index, so a replaced binary is detected even when
+// its size and mtime match.
+func platformRuntimeFileIdentity(path string, _ os.FileInfo) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	var information syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(
+		syscall.Handle(file.Fd()), &information,
+	); err != nil {
+		return ""
+	}
+	return fmt.Sprintf(
+		"%x:%08x%08x", information.VolumeSerialNumber,
+		information.FileIndexHigh, information.FileIndexLow,
+	)
+}
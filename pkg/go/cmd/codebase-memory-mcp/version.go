@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// releaseVersion is a parsed MAJOR.MINOR.PATCH[-PRERELEASE] release version.
+// Build metadata is refused: it does not name a distinct release, and every
+// accepted version string is also used verbatim as a cache directory name.
+type releaseVersion struct {
+	major, minor, patch int
+	prerelease          []string
+}
+
+func parseReleaseVersion(text string) (releaseVersion, error) {
+	var parsed releaseVersion
+	core, prerelease, hasPrerelease := strings.Cut(strings.TrimPrefix(text, "v"), "-")
+	numbers := strings.Split(core, ".")
+	if len(numbers) != 3 {
+		return parsed, fmt.Errorf("%q is not a MAJOR.MINOR.PATCH release version", text)
+	}
+	fields := []*int{&parsed.major, &parsed.minor, &parsed.patch}
+	for index, number := range numbers {
+		value, ok := versionNumber(number)
+		if !ok {
+			return parsed, fmt.Errorf("%q is not a MAJOR.MINOR.PATCH release version", text)
+		}
+		*fields[index] = value
+	}
+	if !hasPrerelease {
+		return parsed, nil
+	}
+	for _, identifier := range strings.Split(prerelease, ".") {
+		if identifier == "" || strings.Trim(identifier,
+			"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+			return parsed, fmt.Errorf("%q has an invalid pre-release identifier", text)
+		}
+		if _, numeric := versionNumber(identifier); !numeric &&
+			strings.Trim(identifier, "0123456789") == "" {
+			return parsed, fmt.Errorf("%q has an invalid pre-release identifier", text)
+		}
+		parsed.prerelease = append(parsed.prerelease, identifier)
+	}
+	return parsed, nil
+}
+
+// versionNumber parses a numeric identifier without a leading zero.
+func versionNumber(text string) (int, bool) {
+	if text == "" || (len(text) > 1 && text[0] == '0') ||
+		strings.Trim(text, "0123456789") != "" {
+		return 0, false
+	}
+	value, err := strconv.Atoi(text)
+	return value, err == nil
+}
+
+func (parsed releaseVersion) String() string {
+	text := fmt.Sprintf("%d.%d.%d", parsed.major, parsed.minor, parsed.patch)
+	if len(parsed.prerelease) > 0 {
+		text += "-" + strings.Join(parsed.prerelease, ".")
+	}
+	return text
+}
+
+// compareReleaseVersions orders versions by semantic-versioning precedence.
+func compareReleaseVersions(left, right releaseVersion) int {
+	if order := cmp.Or(
+		cmp.Compare(left.major, right.major),
+		cmp.Compare(left.minor, right.minor),
+		cmp.Compare(left.patch, right.patch),
+	); order != 0 {
+		return order
+	}
+	switch {
+	case len(left.prerelease) == 0 && len(right.prerelease) == 0:
+		return 0
+	case len(left.prerelease) == 0:
+		return 1
+	case len(right.prerelease) == 0:
+		return -1
+	}
+	for index := range min(len(left.prerelease), len(right.prerelease)) {
+		leftIdentifier, rightIdentifier := left.prerelease[index], right.prerelease[index]
+		leftNumber, leftNumeric := versionNumber(leftIdentifier)
+		rightNumber, rightNumeric := versionNumber(rightIdentifier)
+		var order int
+		switch {
+		case leftNumeric && rightNumeric:
+			order = cmp.Compare(leftNumber, rightNumber)
+		case leftNumeric:
+			order = -1
+		case rightNumeric:
+			order = 1
+		default:
+			order = strings.Compare(leftIdentifier, rightIdentifier)
+		}
+		if order != 0 {
+			return order
+		}
+	}
+	return cmp.Compare(len(left.prerelease), len(right.prerelease))
+}
+
+// selectedVersion returns the native release to launch. CBM_GO_VERSION picks
+// a release other than the one this wrapper was built for, so a newer engine
+// can be tried, or a bad one rolled back, without reinstalling the wrapper.
+// A repository's wrapper.json may pin the same way. CBM_GO_MIN_VERSION is a
+// floor that neither a selection, a pin nor the built-in default may fall
+// below.
+func selectedVersion() (string, error) {
+	selected, err := parseReleaseVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("wrapper release version: %w", err)
+	}
+	source := "this wrapper's default"
+	if requested, from := wrapperSettingSource("CBM_GO_VERSION"); requested != "" {
+		selected, err = parseReleaseVersion(requested)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", from, err)
+		}
+		source = from
+	}
+	if setting := wrapperSetting("CBM_GO_MIN_VERSION"); setting != "" {
+		floor, err := parseReleaseVersion(setting)
+		if err != nil {
+			return "", fmt.Errorf("CBM_GO_MIN_VERSION: %w", err)
+		}
+		if compareReleaseVersions(selected, floor) < 0 {
+			return "", fmt.Errorf(
+				"v%s from %s is below the minimum version v%s set by CBM_GO_MIN_VERSION",
+				selected, source, floor,
+			)
+		}
+	}
+	return selected.String(), nil
+}
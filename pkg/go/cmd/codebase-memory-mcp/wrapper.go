@@ -0,0 +1,500 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+const wrapperUsage = `usage: codebase-memory-mcp wrapper <command>
+
+Commands that manage this Go wrapper itself. They are never passed to the
+native binary.
+
+  --check                report whether launching the selected version would
+                         need the network; exits 1 if it would
+  doctor [--json]        diagnose the cache, its lock and journals, the
+                         installed binary, disk space, the release host and
+                         recent daemon version conflicts; exits 1 on failure
+  lock show [--json]     show who holds the runtime-set lock, its lease and
+                         age, and leftover protocol files
+  lock break [--force]   reclaim the lock from an owner that is not running;
+                         --force also breaks a live owner's lock
+  repair [--accept | --restore=JOURNAL | --discard] [--yes=STATE]
+                         show the publication journals a launch will not
+                         resolve on its own, their digests and a STATE
+                         fingerprint, and recommend a plan; with a plan and
+                         --yes=STATE, carry it out under the runtime-set lock
+                         unless the journals no longer match STATE
+  cache list [--json]    list cached native versions with digests and sizes
+  cache verify           re-verify every cached version
+  cache prune [--dry-run]
+                         remove versions other than the active ones, finished
+                         publication journals, stale lock files and versions
+                         left in the pre-platform cache layout
+`
+
+// runWrapperCommand runs "codebase-memory-mcp wrapper ..." and returns the
+// process exit code: 0 on success, 1 when an operation failed, 2 for usage.
+func runWrapperCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "--help" || args[0] == "-h" || args[0] == "help" {
+		fmt.Fprint(stdout, wrapperUsage)
+		return 0
+	}
+	if args[0] == "--check" && len(args) == 1 {
+		needsNetwork, err := wrapperCheck(stdout)
+		if err != nil {
+			fmt.Fprintf(stderr, "codebase-memory-mcp: %v\n", err)
+		}
+		if err != nil || needsNetwork {
+			return 1
+		}
+		return 0
+	}
+	if args[0] == "doctor" && onlyFlags(flagSet(args[1:]), "--json") {
+		if !wrapperDoctor(slices.Contains(args[1:], "--json"), stdout) {
+			return 1
+		}
+		return 0
+	}
+	if args[0] == "lock" && len(args) >= 2 {
+		flags := flagSet(args[2:])
+		var err error
+		switch {
+		case args[1] == "show" && onlyFlags(flags, "--json"):
+			err = wrapperLockShow(flags["--json"], stdout)
+		case args[1] == "break" && onlyFlags(flags, "--force"):
+			err = wrapperLockBreak(flags["--force"], stdout)
+		default:
+			fmt.Fprint(stderr, wrapperUsage)
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "codebase-memory-mcp: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if args[0] == "repair" {
+		plan, confirmed, ok := parseRuntimeRepairArgs(args[1:])
+		if !ok {
+			fmt.Fprint(stderr, wrapperUsage)
+			return 2
+		}
+		if err := wrapperRepair(plan, confirmed, stdout); err != nil {
+			fmt.Fprintf(stderr, "codebase-memory-mcp: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if args[0] != "cache" || len(args) < 2 {
+		fmt.Fprint(stderr, wrapperUsage)
+		return 2
+	}
+	flags := flagSet(args[2:])
+	var err error
+	switch command := args[1]; {
+	case command == "list" && onlyFlags(flags, "--json"):
+		err = wrapperCacheList(runtimeCacheRoot(), flags["--json"], stdout)
+	case command == "verify" && onlyFlags(flags):
+		err = wrapperCacheVerify(runtimeCacheRoot(), stdout)
+	case command == "prune" && onlyFlags(flags, "--dry-run"):
+		err = wrapperCachePrune(runtimeCacheRoot(), flags["--dry-run"], stdout)
+		if err == nil {
+			err = wrapperCachePruneLegacy(cacheDir(), flags["--dry-run"], stdout)
+		}
+	default:
+		fmt.Fprint(stderr, wrapperUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codebase-memory-mcp: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func flagSet(args []string) map[string]bool {
+	flags := map[string]bool{}
+	for _, argument := range args {
+		flags[argument] = true
+	}
+	return flags
+}
+
+func onlyFlags(flags map[string]bool, allowed ...string) bool {
+	for flag := range flags {
+		if !slices.Contains(allowed, flag) {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedVersions returns the version directories under cache, newest first.
+// Anything that is not exactly a canonical release version is not the
+// wrapper's and is left alone.
+func cachedVersions(cache string) ([]string, error) {
+	entries, err := os.ReadDir(cache)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []releaseVersion
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Type()&fs.ModeSymlink != 0 {
+			continue
+		}
+		parsed, err := parseReleaseVersion(entry.Name())
+		if err != nil || parsed.String() != entry.Name() {
+			continue
+		}
+		versions = append(versions, parsed)
+	}
+	slices.SortFunc(versions, func(left, right releaseVersion) int {
+		return compareReleaseVersions(right, left)
+	})
+	names := make([]string, len(versions))
+	for index, parsed := range versions {
+		names[index] = parsed.String()
+	}
+	return names, nil
+}
+
+// activeVersions are the versions a launch from here would use: the one this
+// wrapper was built for and the one currently selected or pinned.
+func activeVersions() map[string]bool {
+	active := map[string]bool{version: true}
+	if selected, err := selectedVersion(); err == nil {
+		active[selected] = true
+	}
+	return active
+}
+
+// retainedVersions is activeVersions plus the version of a running daemon.
+// The daemon runs from its version directory long after the launch that
+// started it has exited and its launch marker is gone.
+func retainedVersions() map[string]bool {
+	retained := activeVersions()
+	if daemon, running := findRunningDaemon(nativeCacheDir()); running {
+		retained[daemon.Version] = true
+	}
+	return retained
+}
+
+// withRuntimeSetLock runs operation while holding directory's runtime-set
+// lock, so cache maintenance never races a publisher.
+func withRuntimeSetLock(
+	directory string, operation func(*runtimeSetLock) error,
+) (result error) {
+	if err := requireSafeRuntimeDirectory(directory); err != nil {
+		return err
+	}
+	lock, err := acquireRuntimeSetLock(directory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		attachRuntimeLockReleaseError(&result, releaseRuntimeSetLock(lock))
+	}()
+	if err := refreshRuntimeSetLock(lock); err != nil {
+		return err
+	}
+	return operation(lock)
+}
+
+// cachedVersionInfo is one row of "wrapper cache list".
+type cachedVersionInfo struct {
+	Version    string `json:"version"`
+	Active     bool   `json:"active"`
+	Binary     string `json:"binary,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Size       int64  `json:"size_bytes"`
+	Backups    int    `json:"pending_journals"`
+	Provenance string `json:"provenance,omitempty"`
+	Archive    string `json:"archive,omitempty"`
+	Reuses     string `json:"reuses,omitempty"`
+}
+
+func inspectCachedVersion(directory, ver string, active bool) (cachedVersionInfo, error) {
+	info := cachedVersionInfo{Version: ver, Active: active}
+	binaryName := binaryNameForOS(runtime.GOOS)
+	binary := filepath.Join(directory, binaryName)
+	if regularRuntimeFile(binary) {
+		digest, err := fileSHA256(binary)
+		if err != nil {
+			return info, err
+		}
+		info.Binary = binaryName
+		info.SHA256 = hex.EncodeToString(digest[:])
+	} else if record := readRuntimeReuseRecord(directory); record.Reused != nil {
+		info.Reuses = record.Reused.Path
+		info.SHA256 = record.SHA256
+	}
+	if contents, err := readLocalDocument(
+		filepath.Join(directory, provenanceRecordName), maxProvenanceBundleSize,
+	); err == nil {
+		var record provenanceRecord
+		if json.Unmarshal(contents, &record) == nil {
+			info.Provenance, info.Archive = record.Status, record.Archive
+		}
+	}
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != directory && entry.IsDir() && runtimeBackupDirectoryName(entry.Name()) {
+			info.Backups++
+		}
+		if entry.Type().IsRegular() {
+			status, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			info.Size += status.Size()
+		}
+		return nil
+	})
+	return info, err
+}
+
+func wrapperCacheList(cache string, asJSON bool, stdout io.Writer) error {
+	versions, err := cachedVersions(cache)
+	if err != nil {
+		return err
+	}
+	active := activeVersions()
+	rows := make([]cachedVersionInfo, 0, len(versions))
+	for _, ver := range versions {
+		directory := filepath.Join(cache, ver)
+		if err := withRuntimeSetLock(directory, func(*runtimeSetLock) error {
+			info, err := inspectCachedVersion(directory, ver, active[ver])
+			rows = append(rows, info)
+			return err
+		}); err != nil {
+			return fmt.Errorf("v%s: %w", ver, err)
+		}
+	}
+	if asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(stdout, "no cached versions in %s\n", cache)
+		return nil
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VERSION\tACTIVE\tSIZE\tPROVENANCE\tSHA256")
+	for _, row := range rows {
+		digest := row.SHA256
+		switch {
+		case row.Reuses != "":
+			digest = "reuses " + row.Reuses
+		case digest == "":
+			digest = "(no binary)"
+		}
+		activeText := ""
+		if row.Active {
+			activeText = "yes"
+		}
+		provenance := cmp.Or(row.Provenance, "-")
+		if row.Backups > 0 {
+			provenance += fmt.Sprintf(" (%d pending journal(s))", row.Backups)
+		}
+		fmt.Fprintf(
+			table, "%s\t%s\t%.1f MiB\t%s\t%s\n", row.Version, activeText,
+			float64(row.Size)/(1024*1024), provenance, digest,
+		)
+	}
+	return table.Flush()
+}
+
+// wrapperCacheVerify re-verifies every cached version under its lock, the way
+// a launch does. A binary that no longer matches the digest it was verified
+// with, or that now fails verification, loses its verified-state record so
+// the lock-free launch path stops trusting it.
+func wrapperCacheVerify(cache string, stdout io.Writer) error {
+	versions, err := cachedVersions(cache)
+	if err != nil {
+		return err
+	}
+	binaryName := binaryNameForOS(runtime.GOOS)
+	failed := 0
+	for _, ver := range versions {
+		directory := filepath.Join(cache, ver)
+		verifier := cachedVersionVerifier(ver)
+		if !regularRuntimeFile(filepath.Join(directory, binaryName)) {
+			// A version served by an installed binary is confirmed again
+			// by the next launch whenever that binary changes.
+			if record := readRuntimeReuseRecord(directory); record.Reused != nil {
+				fmt.Fprintf(stdout, "v%s: reuses %s\n", ver, record.Reused.Path)
+				continue
+			}
+		}
+		var problem error
+		if err := withRuntimeSetLock(directory, func(lock *runtimeSetLock) error {
+			if err := reconcileRuntimeBackups(directory, binaryName, verifier, lock); err != nil {
+				return err
+			}
+			problem = verifyCachedRuntimeSet(directory, binaryName, verifier)
+			if problem != nil {
+				_ = os.Remove(filepath.Join(directory, runtimeVerifiedRecordName))
+				return nil
+			}
+			recordVerifiedRuntimeSet(directory, binaryName)
+			return nil
+		}); err != nil {
+			problem = err
+		}
+		if problem != nil {
+			failed++
+			fmt.Fprintf(stdout, "v%s: FAILED: %v\n", ver, problem)
+			continue
+		}
+		fmt.Fprintf(stdout, "v%s: ok\n", ver)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d cached version(s) failed verification", failed)
+	}
+	return nil
+}
+
+func verifyCachedRuntimeSet(
+	directory, binaryName string, verifier func(string) error,
+) error {
+	binary := filepath.Join(directory, binaryName)
+	if _, ok := runtimeSetNames(directory, binaryName); !ok {
+		return fmt.Errorf("the runtime set is incomplete")
+	}
+	if record, ok := readRuntimeVerifiedRecord(directory); ok && record.SHA256 != "" {
+		digest, err := fileSHA256(binary)
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(digest[:]) != record.SHA256 {
+			return fmt.Errorf(
+				"%s changed since it was verified (sha256 %s, recorded %s)",
+				binaryName, hex.EncodeToString(digest[:]), record.SHA256,
+			)
+		}
+	}
+	return verifier(binary)
+}
+
+// wrapperCachePrune removes inactive versions and tidies active ones. Each
+// directory is changed only under its own lock; an inactive directory is
+// emptied under the lock and removed after release, which fails harmlessly
+// if a launch has started publishing into it again. A version that a live
+// process launched is kept, and a running daemon's version counts as active.
+func wrapperCachePrune(cache string, dryRun bool, stdout io.Writer) error {
+	versions, err := cachedVersions(cache)
+	if err != nil {
+		return err
+	}
+	action := "removed"
+	if dryRun {
+		action = "would remove"
+	}
+	active := retainedVersions()
+	binaryName := binaryNameForOS(runtime.GOOS)
+	for _, ver := range versions {
+		directory := filepath.Join(cache, ver)
+		var removed []string
+		kept := false
+		err := withRuntimeSetLock(directory, func(lock *runtimeSetLock) error {
+			if active[ver] {
+				entries, err := os.ReadDir(directory)
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					if runtimeBackupDirectoryName(entry.Name()) {
+						removed = append(removed, "v"+ver+"/"+entry.Name())
+					}
+				}
+				// Reconciliation finishes or rolls back each journal
+				// exactly as the next launch would.
+				if !dryRun {
+					if err := reconcileRuntimeBackups(
+						directory, binaryName, cachedVersionVerifier(ver), lock,
+					); err != nil {
+						return err
+					}
+				}
+				stale, err := removeStaleRuntimeLockFiles(directory, dryRun)
+				for _, name := range stale {
+					removed = append(removed, "v"+ver+"/"+name)
+				}
+				return err
+			}
+			if pid, inUse := runtimeVersionInUse(directory); inUse {
+				fmt.Fprintf(stdout, "kept v%s: in use by process %d\n", ver, pid)
+				kept = true
+				return nil
+			}
+			removed = []string{"v" + ver}
+			if dryRun {
+				return nil
+			}
+			return emptyRuntimeVersionDirectory(directory, lock)
+		})
+		if err != nil {
+			return fmt.Errorf("v%s: %w", ver, err)
+		}
+		if !active[ver] && !kept && !dryRun {
+			removeRetiredRuntimeDirectory(directory)
+		}
+		for _, name := range removed {
+			fmt.Fprintf(stdout, "%s %s\n", action, name)
+		}
+	}
+	return nil
+}
+
+// runtimeSetLockProtocolName matches the claim, release and reclaim files of
+// the lock protocol, which a contender may be using even while the lock is
+// held.
+func runtimeSetLockProtocolName(name string) bool {
+	_, ok := runtimeSetLockProtocolKind(name)
+	return ok
+}
+
+// removeStaleRuntimeLockFiles removes claim, release and reclaim files left by
+// processes that died mid-protocol. A live contender finishes with its file
+// well within runtimeSetOwnerlessStale.
+func removeStaleRuntimeLockFiles(directory string, dryRun bool) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, entry := range entries {
+		if !runtimeSetLockProtocolName(entry.Name()) || !entry.Type().IsRegular() {
+			continue
+		}
+		status, err := entry.Info()
+		if err != nil || time.Since(status.ModTime()) < runtimeSetOwnerlessStale {
+			continue
+		}
+		if !dryRun {
+			err := os.Remove(filepath.Join(directory, entry.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed, nil
+}
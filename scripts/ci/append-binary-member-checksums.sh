@@ -0,0 +1,41 @@
+#!/usr/bin/env bash
+# Add the digest of each release archive's binary to checksums.txt.
+#
+# The Go wrapper's CBM_GO_REUSE=on runs a copy of the release that is already
+# installed instead of downloading a second one, but only when the copy's
+# SHA-256 is a binary digest the release publishes. Those digests are listed as
+# archive members, "<digest>  <archive>/<binary>", beside the archive digests.
+# Installers match exact archive names, so the extra lines are invisible to
+# them. This runs BEFORE signing and attestation so both cover the members.
+#
+# The digests come from gen-go-wrapper-checksums.sh with ARCHIVES_DIR, the same
+# extraction the maintainer's pinning step uses, run over the archives in the
+# current directory. It fails closed when an archive is missing or does not
+# match its listed digest.
+#
+# Usage: append-binary-member-checksums.sh <version> <checksums-file>
+set -euo pipefail
+
+case "${1:-}" in
+-h | --help)
+    sed -n '2,16p' "$0" | sed 's/^# \{0,1\}//'
+    exit 0
+    ;;
+esac
+
+VERSION="${1:?usage: append-binary-member-checksums.sh <version> <checksums-file> (see --help)}"
+CHECKSUMS="${2:?usage: append-binary-member-checksums.sh <version> <checksums-file> (see --help)}"
+ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
+
+pinned="$(mktemp)"
+trap 'rm -f "$pinned"' EXIT
+ARCHIVES_DIR="$(dirname "$CHECKSUMS")" \
+    "$ROOT/scripts/ci/gen-go-wrapper-checksums.sh" "$VERSION" "$CHECKSUMS" "$pinned" >/dev/null
+
+members="$(awk '$2 ~ /\// { print $1 "  " $2 }' "$pinned")"
+if [ -z "$members" ]; then
+    echo "error: no binary member digests computed from the archives beside $CHECKSUMS" >&2
+    exit 1
+fi
+printf '%s\n' "$members" >> "$CHECKSUMS"
+echo "added $(printf '%s\n' "$members" | wc -l | tr -d ' ') binary member checksum line(s) to $CHECKSUMS"
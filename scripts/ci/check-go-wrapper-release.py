@@ -0,0 +1,98 @@
+#!/usr/bin/env python3
+"""Refuse a Go wrapper tag that would ship without its release pins.
+
+A tagged pkg/go module is what `go install ...@vX.Y.Z` fetches, and the Go
+checksum database freezes it forever, so anything the wrapper embeds must be
+complete before the tag exists. This checks that:
+
+- release_checksums.txt, written by gen-go-wrapper-checksums.sh, names the
+  wrapper's own version and pins at least one archive digest, because a
+  tagged wrapper without pins refuses to install anything;
+- the signing-key trust list (release_signing_keys.txt) has a key whose
+  release range covers that version, so every install of it requires a
+  signed checksums.txt.
+
+Usage: scripts/ci/check-go-wrapper-release.py <tag>   (e.g. pkg/go/v0.9.1)
+"""
+from __future__ import annotations
+
+import pathlib
+import re
+import sys
+
+if len(sys.argv) != 2 or sys.argv[1] in {"-h", "--help"}:
+    print(__doc__.strip(), file=sys.stderr)
+    sys.exit(0 if len(sys.argv) == 2 else 2)
+
+WRAPPER = pathlib.Path(__file__).resolve().parents[2] / "pkg/go/cmd/codebase-memory-mcp"
+VERSION_RE = re.compile(r"^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?$")
+
+
+def parse_version(text: str) -> tuple:
+    match = VERSION_RE.match(text)
+    if not match:
+        raise SystemExit(f"error: {text!r} is not a release version")
+    major, minor, patch, pre = match.groups()
+    # A release sorts after its pre-releases; numeric identifiers before
+    # alphanumeric ones, as in semantic versioning.
+    identifiers = tuple(
+        (0, int(part), "") if part.isdigit() else (1, 0, part)
+        for part in (pre.split(".") if pre else ())
+    )
+    return (int(major), int(minor), int(patch), not identifiers, identifiers)
+
+
+tag = sys.argv[1]
+tag_version = tag.removeprefix("pkg/go/").removeprefix("v")
+source = (WRAPPER / "main.go").read_text(encoding="utf-8")
+declared = re.search(r'^\s*version\s*=\s*"([^"]+)"', source, re.MULTILINE)
+if not declared:
+    raise SystemExit("error: main.go declares no wrapper version")
+version = declared.group(1)
+failures: list[str] = []
+if parse_version(tag_version) != parse_version(version):
+    failures.append(f"tag {tag} does not match the wrapper version {version}")
+
+header, archives = "", 0
+for line in (WRAPPER / "release_checksums.txt").read_text(encoding="utf-8").splitlines():
+    line = line.strip()
+    if not line:
+        continue
+    if line.startswith("#"):
+        header = header or line
+        continue
+    fields = line.split()
+    if len(fields) == 2 and fields[1].endswith((".tar.gz", ".zip")):
+        archives += 1
+pinned_version = header.removeprefix("# codebase-memory-mcp ").removeprefix("v")
+if pinned_version != version:
+    failures.append(f"release_checksums.txt is for {pinned_version or 'no version'}, not {version}")
+if archives == 0:
+    failures.append(
+        "release_checksums.txt pins no archive digests; run "
+        "scripts/ci/gen-go-wrapper-checksums.sh on the published checksums.txt"
+    )
+
+covered = False
+for line in (WRAPPER / "release_signing_keys.txt").read_text(encoding="utf-8").splitlines():
+    fields = line.split()
+    if not fields or fields[0].startswith("#"):
+        continue
+    if len(fields) not in (2, 3):
+        failures.append(f"release_signing_keys.txt: malformed line {line!r}")
+        continue
+    first = parse_version(fields[1])
+    last = parse_version(fields[2]) if len(fields) == 3 else None
+    if first <= parse_version(version) and (last is None or parse_version(version) <= last):
+        covered = True
+if not covered:
+    failures.append(
+        f"release_signing_keys.txt has no key covering {version}; "
+        "add the release public key before tagging"
+    )
+
+if failures:
+    for failure in failures:
+        print(f"error: {failure}", file=sys.stderr)
+    sys.exit(1)
+print(f"Go wrapper {version} pins are complete")
@@ -0,0 +1,98 @@
+#!/usr/bin/env bash
+# gen-go-wrapper-checksums.sh — pin release archive digests into the Go wrapper.
+#
+# The Go wrapper embeds pkg/go/cmd/codebase-memory-mcp/release_checksums.txt,
+# so a `go install` of a tagged pkg/go module verifies the archive against data
+# protected by the Go checksum database instead of a manifest fetched from the
+# same host as the archive. Run this on the published checksums.txt, commit the
+# result, and only then tag pkg/go/v<version>.
+#
+# Only canonical platform archives are kept (no ui-* aliases, no .mcpb). The
+# header line carries the version; the wrapper refuses pinned digests for any
+# other version. The digest of each archive's binary is pinned too, as
+# "<archive>/<binary>", so CBM_GO_REUSE=on can confirm an already-installed
+# copy without downloading: taken from checksums.txt, which release.yml fills
+# through append-binary-member-checksums.sh, or recomputed from the downloaded
+# archives when ARCHIVES_DIR points at them.
+#
+# Usage: [ARCHIVES_DIR=dir] scripts/ci/gen-go-wrapper-checksums.sh <version> <checksums-file> [output]
+set -euo pipefail
+
+case "${1:-}" in
+-h | --help)
+    sed -n '2,18p' "$0" | sed 's/^# \{0,1\}//'
+    exit 0
+    ;;
+esac
+
+VERSION="${1:?usage: gen-go-wrapper-checksums.sh <version> <checksums-file> [output] (see --help)}"
+CHECKSUMS="${2:?usage: gen-go-wrapper-checksums.sh <version> <checksums-file> [output] (see --help)}"
+ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
+OUTPUT="${3:-$ROOT/pkg/go/cmd/codebase-memory-mcp/release_checksums.txt}"
+VERSION="${VERSION#v}"
+
+if [ ! -s "$CHECKSUMS" ]; then
+    echo "error: $CHECKSUMS is missing or empty" >&2
+    exit 1
+fi
+
+pinned="$(mktemp)"
+trap 'rm -f "$pinned"' EXIT
+awk '
+  length($1) == 64 && $1 ~ /^[0-9a-fA-F]+$/ &&
+  $2 ~ /^\*?codebase-memory-mcp-/ &&
+  $2 !~ /^\*?codebase-memory-mcp-ui-/ &&
+  ($2 ~ /\.tar\.gz$/ || $2 ~ /\.zip$/ ||
+   $2 ~ /\.(tar\.gz|zip)\/codebase-memory-mcp(\.exe)?$/) {
+    name = $2
+    sub(/^\*/, "", name)
+    print tolower($1) "  " name
+  }
+' "$CHECKSUMS" | LC_ALL=C sort -k2 > "$pinned"
+
+if [ ! -s "$pinned" ]; then
+    echo "error: no canonical release archives found in $CHECKSUMS" >&2
+    exit 1
+fi
+
+sha256() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum | awk '{print $1}'
+    else
+        shasum -a 256 | awk '{print $1}'
+    fi
+}
+
+if [ -n "${ARCHIVES_DIR:-}" ]; then
+    members="$(mktemp)"
+    trap 'rm -f "$pinned" "$members"' EXIT
+    # The archives are the authority here, not member lines already listed.
+    awk '$2 !~ /\//' "$pinned" > "$members"
+    mv "$members" "$pinned"
+    while read -r digest name; do
+        archive="$ARCHIVES_DIR/$name"
+        if [ ! -f "$archive" ]; then
+            echo "error: $archive is missing" >&2
+            exit 1
+        fi
+        if [ "$(sha256 < "$archive")" != "$digest" ]; then
+            echo "error: $archive does not match its published digest" >&2
+            exit 1
+        fi
+        case "$name" in
+        *.zip) binary="codebase-memory-mcp.exe"
+            member_digest="$(unzip -p "$archive" "$binary" | sha256)" ;;
+        *) binary="codebase-memory-mcp"
+            member_digest="$(tar -xOzf "$archive" "$binary" | sha256)" ;;
+        esac
+        echo "$member_digest  $name/$binary" >> "$members"
+    done < "$pinned"
+    cat "$members" >> "$pinned"
+    LC_ALL=C sort -k2 -o "$pinned" "$pinned"
+fi
+
+{
+    echo "# codebase-memory-mcp $VERSION"
+    cat "$pinned"
+} > "$OUTPUT"
+echo "pinned $(wc -l < "$pinned" | tr -d ' ') digest(s) for $VERSION in $OUTPUT"
@@ -0,0 +1,67 @@
+#!/usr/bin/env bash
+# sign-go-wrapper-checksums.sh — sign checksums.txt for the Go wrapper.
+#
+# Writes <checksums-file>.minisig: a legacy Ed25519 minisign signature (the
+# only form the wrapper verifies) whose trusted comment names the release
+# version. The wrapper refuses an unsigned checksums.txt for every release its
+# embedded trust list, pkg/go/cmd/codebase-memory-mcp/release_signing_keys.txt,
+# covers, and trusts a key only for the versions on its line there: the
+# version in the comment, never the timestamp, is what bounds a key.
+#
+# MINISIGN_SECRET_KEY holds the whole secret key file, MINISIGN_PASSWORD its
+# password. The signature is verified against the embedded trust list before
+# this exits, so a secret that matches no embedded key fails the release
+# rather than every install. While the trust list holds no key yet, no
+# wrapper requires a signature, so this leaves checksums.txt unsigned and
+# exits 0 without needing the secret.
+#
+# Usage: scripts/ci/sign-go-wrapper-checksums.sh <version> <checksums-file>
+set -euo pipefail
+
+case "${1:-}" in
+-h | --help)
+    sed -n '2,18p' "$0" | sed 's/^# \{0,1\}//'
+    exit 0
+    ;;
+esac
+
+VERSION="${1:?usage: sign-go-wrapper-checksums.sh <version> <checksums-file> (see --help)}"
+CHECKSUMS="${2:?usage: sign-go-wrapper-checksums.sh <version> <checksums-file> (see --help)}"
+ROOT="$(cd "$(dirname "$0")/../.." && pwd)"
+TRUST_LIST="$ROOT/pkg/go/cmd/codebase-memory-mcp/release_signing_keys.txt"
+VERSION="${VERSION#v}"
+
+if ! grep -qv -e '^#' -e '^[[:space:]]*$' "$TRUST_LIST"; then
+    echo "notice: $TRUST_LIST lists no signing key yet; leaving $CHECKSUMS unsigned"
+    exit 0
+fi
+if [ -z "${MINISIGN_SECRET_KEY:-}" ]; then
+    echo "error: MINISIGN_SECRET_KEY is not set; checksums.txt cannot be signed" >&2
+    exit 1
+fi
+if ! command -v minisign >/dev/null 2>&1; then
+    echo "error: minisign is not installed" >&2
+    exit 1
+fi
+if [ ! -s "$CHECKSUMS" ]; then
+    echo "error: $CHECKSUMS is missing or empty" >&2
+    exit 1
+fi
+
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+(umask 077 && printf '%s\n' "$MINISIGN_SECRET_KEY" > "$work/release.key")
+comment="$(printf 'timestamp:%s\tfile:%s\tversion:%s' "$(date +%s)" "$(basename "$CHECKSUMS")" "$VERSION")"
+printf '%s\n' "${MINISIGN_PASSWORD:-}" |
+    minisign -S -l -s "$work/release.key" -m "$CHECKSUMS" -x "$CHECKSUMS.minisig" -t "$comment"
+
+while read -r key _; do
+    case "$key" in '' | '#'*) continue ;; esac
+    if minisign -V -q -P "$key" -m "$CHECKSUMS" -x "$CHECKSUMS.minisig" >/dev/null 2>&1; then
+        echo "signed $CHECKSUMS for $VERSION with embedded key ${key:0:16}..."
+        exit 0
+    fi
+done < "$TRUST_LIST"
+echo "error: the signature does not verify with any key in $TRUST_LIST" >&2
+rm -f "$CHECKSUMS.minisig"
+exit 1
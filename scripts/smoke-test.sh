@@ -3409,7 +3409,7 @@ if ! curl --noproxy '*' -fsSL -o "$DL_DIR/checksums.txt" \
 fi
 
 # 12c: verify checksum
-EXPECTED=$(grep "$DL_ARCHIVE" "$DL_DIR/checksums.txt" | awk '{print $1}')
+EXPECTED=$(awk -v archive="$DL_ARCHIVE" '$2 == archive { print $1 }' "$DL_DIR/checksums.txt")
 if [ -z "$EXPECTED" ]; then
   echo "FAIL 12c: archive not found in checksums.txt"
   exit 1